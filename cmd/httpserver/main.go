@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"log"
@@ -10,37 +11,23 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
+	"webserver/internal/fileserver"
 	"webserver/internal/headers"
 	"webserver/internal/request"
 	"webserver/internal/response"
+	"webserver/internal/router"
 	"webserver/internal/server"
 )
 
 const port = 42069
 
-func respond400() []byte {
-	return []byte(`<html>
-  <head>
-    <title>400 Bad Request</title>
-  </head>
-  <body>
-    <h1>Bad Request</h1>
-    <p>Your request honestly kinda sucked.</p>
-  </body>
-</html>`)
-}
+// shutdownTimeout bounds how long in-flight requests get to finish once a
+// stop signal arrives.
+const shutdownTimeout = 10 * time.Second
 
-func respond500() []byte {
-	return []byte(`<html>
-  <head>
-    <title>500 Internal Server Error</title>
-  </head>
-  <body>
-    <h1>Internal Server Error</h1>
-    <p>Okay, you know what? This one is on me.</p>
-  </body>
-</html>`)
-}
+const yourProblemMessage = "Your request honestly kinda sucked."
+const myProblemMessage = "Okay, you know what? This one is on me."
 
 func respond200() []byte {
 	return []byte(`<html>
@@ -54,83 +41,96 @@ func respond200() []byte {
 </html>`)
 }
 
-func main() {
-	s, err := server.Serve(port, func(w *response.Writer, req *request.Request) {
-		h := response.GetDefaultHeaders(0)
-		body := respond200()
-		statusCode := response.StatusOK
-		switch req.RequestLine.RequestTarget {
-		case "/yourproblem":
-			body = respond400()
-			statusCode = response.StatusBadRequest
-		case "/myproblem":
-			body = respond500()
-			statusCode = response.StatusInternalServerError
-		case "/video":
-
-			f, err := os.ReadFile("assets/vim.mp4")
-			if err != nil {
-				body = respond500()
-				statusCode = response.StatusInternalServerError
-			} else {
-				h.Replace("Content-Type", "video/mp4")
-				h.Replace("Content-Length", strconv.Itoa(len(f)))
-
-				w.WriteStatusLine(response.StatusOK)
-				w.WriteHeaders(h)
-				w.WriteBody(f)
-				return
-			}
-		default:
-			if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-				target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-				resp, err := http.Get("https://httpbin.org/" + target)
-				if err != nil {
-					body = respond500()
-					statusCode = response.StatusInternalServerError
-				} else {
-					w.WriteStatusLine(response.StatusOK)
-					h.Delete("Content-Length")
-					h.Set("Transfer-Encoding", "chunked")
-					h.Replace("Content-Type", "text/plain")
-					h.Set("Trailer", "X-Content-SHA256")
-					h.Set("Trailer", "X-Content-Length")
-					w.WriteHeaders(h)
-
-					fullBody := []byte{}
-					for {
-						data := make([]byte, 32)
-						n, err := resp.Body.Read(data)
-						if err != nil {
-							break
-						}
-						fullBody = append(fullBody, data[:n]...)
-						w.WriteChunkedBody(data[:n])
-					}
-					w.WriteChunkedBodyDone()
-					trailers := headers.NewHeaders()
-					trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sha256.Sum256(fullBody)))
-					trailers.Set("X-Content-Length", strconv.Itoa(len(fullBody)))
-					w.WriteTrailers(trailers)
-
-					return
-				}
-			}
+func writeHTML(w *response.Writer, statusCode response.StatusCode, body []byte) error {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/html")
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	_, err := w.WriteBody(body)
+	return err
+}
+
+func handleRoot(w *response.Writer, req *request.Request) error {
+	return writeHTML(w, response.StatusOK, respond200())
+}
+
+func handleYourProblem(w *response.Writer, req *request.Request) error {
+	return &server.HandlerError{StatusCode: response.StatusBadRequest, Message: yourProblemMessage}
+}
+
+func handleMyProblem(w *response.Writer, req *request.Request) error {
+	return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: myProblemMessage}
+}
+
+func handleVideo(w *response.Writer, req *request.Request) error {
+	return fileserver.ServeFile(w, req, "assets/vim.mp4")
+}
+
+func handleHttpbin(w *response.Writer, req *request.Request) error {
+	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
+	resp, err := http.Get("https://httpbin.org/" + target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	h := headers.NewHeaders()
+	w.WriteStatusLine(response.StatusOK)
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Content-Type", "text/plain")
+	h.Set("Trailer", "X-Content-SHA256")
+	h.Set("Trailer", "X-Content-Length")
+	w.WriteHeaders(h)
+
+	fullBody := []byte{}
+	for {
+		data := make([]byte, 32)
+		n, err := resp.Body.Read(data)
+		if err != nil {
+			break
 		}
-		h.Replace("Content-Length", strconv.Itoa(len(body)))
-		h.Replace("Content-Type", "text/html")
-		w.WriteStatusLine(statusCode)
-		w.WriteHeaders(h)
-		w.WriteBody(body)
+		fullBody = append(fullBody, data[:n]...)
+		w.WriteChunkedBody(data[:n])
+	}
+	w.WriteChunkedBodyDone()
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sha256.Sum256(fullBody)))
+	trailers.Set("X-Content-Length", strconv.Itoa(len(fullBody)))
+	return w.WriteTrailers(trailers)
+}
+
+func main() {
+	r := router.New()
+	r.Handle("GET /", handleRoot)
+	r.Handle("GET /yourproblem", handleYourProblem)
+	r.Handle("GET /myproblem", handleMyProblem)
+	r.Handle("GET /video", handleVideo)
+	assets := fileserver.New("assets")
+	assets.PathParam = "path"
+	assets.Listing = fileserver.ListingOn
+	r.Handle("GET /assets/{path...}", assets.Serve)
+	r.Handle("GET /httpbin/{path...}", handleHttpbin)
+
+	handler := server.Chain(r.Serve, server.Logger(nil), server.RequestID(), server.Compress(0), server.Recover(nil))
+	s, err := server.ServeConfig(server.Config{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
 	})
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	defer server.Close(s)
-	log.Println("Server started on port", port)
+	log.Println("Server started on", server.Addr(s))
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx, s); err != nil {
+		log.Printf("Server did not stop gracefully: %v", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
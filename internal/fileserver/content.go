@@ -0,0 +1,143 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+	"webserver/internal/server"
+)
+
+// ServeContent answers req with content, honoring a Range header on GET
+// requests: a single range is sent as a 206 with Content-Range, several as a
+// multipart/byteranges 206, and a range past the end gets a 416. The
+// Content-Type comes from name's extension, or from sniffing the content.
+// HEAD requests get the headers of a full response without the body.
+//
+// Conditional requests are answered with a 304 or 412 as appropriate. The
+// validators are modtime, unless it is zero, and the ETag already set on w's
+// headers; without one, a weak ETag is derived from the size and modtime.
+func ServeContent(w *response.Writer, req *request.Request, name string, modtime time.Time, content io.ReadSeeker) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	validators := response.Validators{LastModified: modtime}
+	if etag, ok := w.Headers().Get("ETag"); ok {
+		validators.ETag = etag
+	} else if !modtime.IsZero() {
+		validators.ETag = response.WeakETag(size, modtime)
+	}
+	if done, err := server.CheckPreconditions(w, req, validators); done || err != nil {
+		return err
+	}
+	head := make([]byte, min(sniffLen, size))
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(content, head); err != nil {
+		return err
+	}
+	mediaType := contentType(name, head)
+
+	rangeHeader, hasRange := req.Headers.Get("Range")
+	if !hasRange || req.RequestLine.Method != "GET" || !response.IfRangeMatches(req.Headers, validators) {
+		return serveWhole(w, req, content, mediaType, size)
+	}
+	ranges, err := parseRange(rangeHeader, size)
+	switch {
+	case errors.Is(err, ErrorUnsatisfiableRange):
+		w.Headers().Replace("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return &server.HandlerError{StatusCode: response.StatusRangeNotSatisfiable}
+	case err != nil:
+		return serveWhole(w, req, content, mediaType, size)
+	case len(ranges) == 1:
+		return serveRange(w, content, mediaType, size, ranges[0])
+	}
+	return serveMultipart(w, content, mediaType, size, ranges)
+}
+
+func serveWhole(w *response.Writer, req *request.Request, content io.ReadSeeker, mediaType string, size int64) error {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := headers.NewHeaders()
+	h.Set("Content-Type", mediaType)
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+	h.Set("Accept-Ranges", "bytes")
+	w.WriteStatusLine(response.StatusOK)
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if isHead(req) {
+		return nil
+	}
+	return copyBody(w, content)
+}
+
+func serveRange(w *response.Writer, content io.ReadSeeker, mediaType string, size int64, r byteRange) error {
+	if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	h := headers.NewHeaders()
+	h.Set("Content-Type", mediaType)
+	h.Set("Content-Length", strconv.FormatInt(r.length, 10))
+	h.Set("Content-Range", r.contentRange(size))
+	h.Set("Accept-Ranges", "bytes")
+	w.WriteStatusLine(response.StatusPartialContent)
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	return copyBody(w, io.LimitReader(content, r.length))
+}
+
+// serveMultipart sends ranges as a multipart/byteranges body. Every part
+// header is known up front, so the body gets an exact Content-Length.
+func serveMultipart(w *response.Writer, content io.ReadSeeker, mediaType string, size int64, ranges []byteRange) error {
+	boundary := newBoundary()
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		partHeaders[i] = fmt.Sprintf("--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, mediaType, r.contentRange(size))
+		length += int64(len(partHeaders[i])) + r.length + int64(len("\r\n"))
+	}
+	closing := "--" + boundary + "--\r\n"
+	length += int64(len(closing))
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	h.Set("Accept-Ranges", "bytes")
+	w.WriteStatusLine(response.StatusPartialContent)
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(partHeaders[i])); err != nil {
+			return err
+		}
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		if err := copyBody(w, io.LimitReader(content, r.length)); err != nil {
+			return err
+		}
+		if _, err := w.WriteBody([]byte("\r\n")); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteBody([]byte(closing))
+	return err
+}
+
+func newBoundary() string {
+	b := make([]byte, 15)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
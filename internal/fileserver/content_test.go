@@ -0,0 +1,146 @@
+package fileserver
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+	"time"
+	"webserver/internal/request"
+	"webserver/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeContent(t *testing.T) {
+	const content = "0123456789abcdefghij"
+	handler := func(w *response.Writer, req *request.Request) error {
+		return ServeContent(w, req, "digits.txt", time.Time{}, strings.NewReader(content))
+	}
+
+	// Test: No Range gets the whole content and advertises ranges
+	resp, body, err := serve(t, handler, "/", "")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, content, body)
+
+	// Test: Single range
+	resp, body, err = serve(t, handler, "/", "Range: bytes=5-9\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 5-9/20", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "5", resp.Header.Get("Content-Length"))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "56789", body)
+
+	resp, body, err = serve(t, handler, "/", "Range: bytes=-3\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "bytes 17-19/20", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "hij", body)
+
+	// Test: Multiple ranges are sent as multipart/byteranges
+	resp, body, err = serve(t, handler, "/", "Range: bytes=0-1, 10-12\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 206, resp.StatusCode)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	expected := []struct{ contentRange, body string }{
+		{"bytes 0-1/20", "01"},
+		{"bytes 10-12/20", "abc"},
+	}
+	for _, e := range expected {
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, e.contentRange, part.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		partBody, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, e.body, string(partBody))
+	}
+	_, err = reader.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Unsatisfiable range is a 416 with the content size
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nRange: bytes=50-\r\n\r\n"))
+	require.NoError(t, err)
+	w := response.NewWriter(io.Discard)
+	err = ServeContent(w, req, "digits.txt", time.Time{}, strings.NewReader(content))
+	requireStatus(t, err, response.StatusRangeNotSatisfiable)
+	value, _ := w.Headers().Get("Content-Range")
+	assert.Equal(t, "bytes */20", value)
+
+	// Test: Malformed Range is ignored
+	resp, body, err = serve(t, handler, "/", "Range: bytes=9-1\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, content, body)
+
+	// Test: Overlapping ranges are sent once as a single range
+	resp, body, err = serve(t, handler, "/", "Range: bytes=0-4, 3-7\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 0-7/20", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "01234567", body)
+
+	// Test: Repeating the whole content is answered with it once
+	resp, body, err = serve(t, handler, "/", "Range: bytes=0-"+strings.Repeat(",0-", maxRanges-1)+"\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, content, body)
+}
+
+func TestServeContentConditional(t *testing.T) {
+	const content = "0123456789"
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	handler := func(w *response.Writer, req *request.Request) error {
+		return ServeContent(w, req, "digits.txt", modified, strings.NewReader(content))
+	}
+
+	// Test: Validators are sent with the content
+	resp, _, err := serve(t, handler, "/", "")
+	require.NoError(t, err)
+	etag := resp.Header.Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", resp.Header.Get("Last-Modified"))
+
+	// Test: Revalidation gets a 304 without a body
+	resp, body, err := serve(t, handler, "/", "If-None-Match: "+etag+"\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Empty(t, body)
+
+	resp, _, err = serve(t, handler, "/", "If-Modified-Since: Wed, 01 May 2024 12:00:00 GMT\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 304, resp.StatusCode)
+
+	// Test: Failed If-Match is a 412
+	_, _, err = serve(t, handler, "/", "If-Match: \"other\"\r\n")
+	requireStatus(t, err, response.StatusPreconditionFailed)
+
+	// Test: If-Range with the current date honors the range, anything else sends everything
+	resp, body, err = serve(t, handler, "/", "Range: bytes=0-1\r\nIf-Range: Wed, 01 May 2024 12:00:00 GMT\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "01", body)
+	resp, body, err = serve(t, handler, "/", "Range: bytes=0-1\r\nIf-Range: "+etag+"\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, content, body)
+
+	// Test: ETag set by the handler is used instead of the derived one
+	handler = func(w *response.Writer, req *request.Request) error {
+		w.Headers().Replace("ETag", `"v1"`)
+		return ServeContent(w, req, "digits.txt", modified, strings.NewReader(content))
+	}
+	resp, body, err = serve(t, handler, "/", "Range: bytes=0-1\r\nIf-Range: \"v1\"\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "01", body)
+}
@@ -0,0 +1,236 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+	"webserver/internal/server"
+)
+
+// copyBufferSize is how much of a file is read per body write.
+const copyBufferSize = 32 * 1024
+
+// Listing selects whether and how directories without an index file are
+// listed.
+type Listing int
+
+const (
+	// ListingOff answers directory requests without an index with a 404.
+	ListingOff Listing = iota
+	// ListingOn lists directories as HTML, or as JSON for clients that
+	// prefer application/json.
+	ListingOn
+)
+
+// FileServer serves the files below a root directory. Its Serve method is a
+// server.Handler.
+type FileServer struct {
+	fsys fs.FS
+
+	// PathParam names the router path parameter holding the file path, as
+	// in "GET /static/{path...}". When empty, the path of the request
+	// target is used.
+	PathParam string
+	// IndexFile is served for a directory that contains it. Empty disables
+	// index files.
+	IndexFile string
+	// Listing controls directory listings.
+	Listing Listing
+}
+
+// New returns a FileServer rooted at dir that serves index.html files and
+// does not list directories.
+func New(dir string) *FileServer {
+	return NewFS(os.DirFS(dir))
+}
+
+// NewFS is like New but serves the files of fsys.
+func NewFS(fsys fs.FS) *FileServer {
+	return &FileServer{
+		fsys:      fsys,
+		IndexFile: "index.html",
+	}
+}
+
+// Serve answers req with the file or directory it names. Paths are cleaned
+// before use, so they cannot reach outside the root. Methods other than GET
+// and HEAD get a 405.
+func (f *FileServer) Serve(w *response.Writer, req *request.Request) error {
+	if method := req.RequestLine.Method; method != "GET" && method != "HEAD" {
+		w.Headers().Replace("Allow", "GET, HEAD")
+		return &server.HandlerError{StatusCode: response.StatusMethodNotAllowed}
+	}
+	urlPath, err := f.requestPath(req)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusBadRequest}
+	}
+	name, ok := cleanName(urlPath)
+	if !ok {
+		return &server.HandlerError{StatusCode: response.StatusNotFound}
+	}
+
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return notFound(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return notFound(err)
+	}
+	if !info.IsDir() {
+		return serveFile(w, req, file, info)
+	}
+
+	// Directory contents are linked relative to the directory, which only
+	// works if its URL ends in a slash. That is the URL of the request, not
+	// the path rebuilt from a path parameter.
+	if target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?"); !strings.HasSuffix(target, "/") {
+		return redirectToSlash(w, req.RequestLine.RequestTarget)
+	}
+	if f.IndexFile != "" {
+		if index, err := f.fsys.Open(path.Join(name, f.IndexFile)); err == nil {
+			defer index.Close()
+			if indexInfo, err := index.Stat(); err == nil && indexInfo.Mode().IsRegular() {
+				return serveFile(w, req, index, indexInfo)
+			}
+		}
+	}
+	if f.Listing == ListingOff {
+		return &server.HandlerError{StatusCode: response.StatusNotFound}
+	}
+	return serveListing(w, req, f.fsys, name, urlPath)
+}
+
+// ServeFile answers req with the named file from the local file system. The
+// name is used as given, so it must not come from the request.
+func ServeFile(w *response.Writer, req *request.Request, name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return notFound(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return notFound(err)
+	}
+	if !info.Mode().IsRegular() {
+		return &server.HandlerError{StatusCode: response.StatusNotFound}
+	}
+	return serveFile(w, req, file, info)
+}
+
+func (f *FileServer) requestPath(req *request.Request) (string, error) {
+	if f.PathParam != "" {
+		// The router has already unescaped path parameters.
+		return "/" + req.PathParam(f.PathParam), nil
+	}
+	target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	return url.PathUnescape(target)
+}
+
+// cleanName turns a URL path into a name within the served file system.
+// Dot-dot segments cannot climb above the root, since the path is cleaned
+// as an absolute one first.
+func cleanName(urlPath string) (string, bool) {
+	if strings.ContainsAny(urlPath, "\x00\\") {
+		return "", false
+	}
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrInvalid) {
+		return &server.HandlerError{StatusCode: response.StatusNotFound}
+	}
+	return err
+}
+
+func redirectToSlash(w *response.Writer, target string) error {
+	p, query, hasQuery := strings.Cut(target, "?")
+	location := p + "/"
+	if hasQuery {
+		location += "?" + query
+	}
+	h := headers.NewHeaders()
+	h.Set("Location", location)
+	h.Set("Content-Length", "0")
+	w.WriteStatusLine(response.StatusPermanentRedirect)
+	return w.WriteHeaders(h)
+}
+
+// serveFile serves file with ServeContent if it can seek, and otherwise
+// streams it whole.
+func serveFile(w *response.Writer, req *request.Request, file fs.File, info fs.FileInfo) error {
+	if content, ok := file.(io.ReadSeeker); ok {
+		return ServeContent(w, req, info.Name(), info.ModTime(), content)
+	}
+	return serveStream(w, req, file, info)
+}
+
+// serveStream streams file with its Content-Type and Content-Length. The
+// type comes from the file extension, or from sniffing the first bytes.
+// Without seeking there are no ranges, and Last-Modified is the only
+// validator.
+func serveStream(w *response.Writer, req *request.Request, file io.Reader, info fs.FileInfo) error {
+	validators := response.Validators{LastModified: info.ModTime()}
+	if done, err := server.CheckPreconditions(w, req, validators); done || err != nil {
+		return err
+	}
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	head = head[:n]
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", contentType(info.Name(), head))
+	h.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteStatusLine(response.StatusOK)
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if isHead(req) {
+		return nil
+	}
+	return copyBody(w, io.MultiReader(bytes.NewReader(head), file))
+}
+
+// isHead reports whether req only wants the headers of the response.
+func isHead(req *request.Request) bool {
+	return req.RequestLine.Method == "HEAD"
+}
+
+// copyBody writes everything from r as the response body without holding
+// more than one buffer of it in memory.
+func copyBody(w *response.Writer, r io.Reader) error {
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.WriteBody(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"webserver/internal/request"
+	"webserver/internal/response"
+	"webserver/internal/router"
+	"webserver/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs handler on a GET for target and returns the response it wrote,
+// or the error it returned.
+func serve(t *testing.T, handler server.Handler, target string, extraHeaders string) (*http.Response, string, error) {
+	t.Helper()
+	req, err := request.RequestFromReader(bytes.NewBufferString("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n" + extraHeaders + "\r\n"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	if err := handler(w, req); err != nil {
+		return nil, "", err
+	}
+	require.NoError(t, w.Finish())
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body), nil
+}
+
+// serveHead runs handler on a HEAD for target with the body omitted, as the
+// server does, and returns the raw response and the body bytes the handler
+// wrote.
+func serveHead(t *testing.T, handler server.Handler, target string) (string, int) {
+	t.Helper()
+	req, err := request.RequestFromReader(bytes.NewBufferString("HEAD " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	w.OmitBody()
+	require.NoError(t, handler(w, req))
+	require.NoError(t, w.Finish())
+	assert.False(t, w.CloseAfter())
+	return out.String(), w.BodyBytes()
+}
+
+func requireStatus(t *testing.T, err error, statusCode response.StatusCode) {
+	t.Helper()
+	var handlerErr *server.HandlerError
+	require.ErrorAs(t, err, &handlerErr)
+	assert.Equal(t, statusCode, handlerErr.StatusCode)
+}
+
+var testFS = fstest.MapFS{
+	"hello.txt":          {Data: []byte("hello world")},
+	"page.html":          {Data: []byte("<p>page</p>")},
+	"noext":              {Data: []byte("<!DOCTYPE html><html></html>")},
+	"image":              {Data: []byte("\x89PNG\r\n\x1a\n\x00\x00")},
+	"blob":               {Data: []byte{0x00, 0x01, 0x02}},
+	"big.txt":            {Data: bytes.Repeat([]byte("a"), 3*copyBufferSize+5)},
+	"site/index.html":    {Data: []byte("<h1>index</h1>")},
+	"docs/a & b.txt":     {Data: []byte("ab")},
+	"docs/sub/deep.txt":  {Data: []byte("deep")},
+	"docs/<script>.html": {Data: []byte("x")},
+}
+
+func TestFileServer(t *testing.T) {
+	fileServer := NewFS(testFS)
+
+	// Test: File with a known extension
+	resp, body, err := serve(t, fileServer.Serve, "/hello.txt", "")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "11", resp.Header.Get("Content-Length"))
+	assert.Equal(t, "hello world", body)
+
+	// Test: Content is sniffed when the extension is unknown
+	for name, mediaType := range map[string]string{
+		"/noext": "text/html; charset=utf-8",
+		"/image": "image/png",
+		"/blob":  "application/octet-stream",
+	} {
+		resp, _, err = serve(t, fileServer.Serve, name, "")
+		require.NoError(t, err)
+		assert.Equal(t, mediaType, resp.Header.Get("Content-Type"), name)
+	}
+
+	// Test: Large file is streamed whole
+	resp, body, err = serve(t, fileServer.Serve, "/big.txt", "")
+	require.NoError(t, err)
+	assert.Len(t, body, 3*copyBufferSize+5)
+
+	// Test: Escaped path and query string
+	_, body, err = serve(t, fileServer.Serve, "/docs/a%20%26%20b.txt?download=1", "")
+	require.NoError(t, err)
+	assert.Equal(t, "ab", body)
+
+	// Test: Traversal cannot leave the root
+	_, body, err = serve(t, fileServer.Serve, "/docs/../../hello.txt", "")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", body)
+	_, _, err = serve(t, fileServer.Serve, "/..%2f..%2fetc/passwd", "")
+	requireStatus(t, err, response.StatusNotFound)
+
+	// Test: Missing file is a 404
+	_, _, err = serve(t, fileServer.Serve, "/missing.txt", "")
+	requireStatus(t, err, response.StatusNotFound)
+
+	// Test: Directory without a trailing slash is redirected
+	resp, _, err = serve(t, fileServer.Serve, "/site?x=1", "")
+	require.NoError(t, err)
+	assert.Equal(t, 308, resp.StatusCode)
+	assert.Equal(t, "/site/?x=1", resp.Header.Get("Location"))
+
+	// Test: Directory with an index file serves it
+	_, body, err = serve(t, fileServer.Serve, "/site/", "")
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>index</h1>", body)
+
+	// Test: Directory without an index is a 404 when listings are off
+	_, _, err = serve(t, fileServer.Serve, "/docs/", "")
+	requireStatus(t, err, response.StatusNotFound)
+
+	// Test: Methods other than GET and HEAD are a 405
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		req, err := request.RequestFromReader(strings.NewReader(method + " /hello.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n"))
+		require.NoError(t, err)
+		w := response.NewWriter(io.Discard)
+		err = fileServer.Serve(w, req)
+		requireStatus(t, err, response.StatusMethodNotAllowed)
+		allow, _ := w.Headers().Get("Allow")
+		assert.Equal(t, "GET, HEAD", allow, method)
+	}
+
+	// Test: HEAD gets the file headers without copying the body
+	out, written := serveHead(t, fileServer.Serve, "/big.txt")
+	assert.Contains(t, out, "Content-Length: 98309\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.Zero(t, written)
+
+	// Test: HEAD on a file that cannot seek is not streamed either
+	info, err := testFS.Stat("big.txt")
+	require.NoError(t, err)
+	out, written = serveHead(t, func(w *response.Writer, req *request.Request) error {
+		return serveStream(w, req, bytes.NewReader(testFS["big.txt"].Data), info)
+	}, "/big.txt")
+	assert.Contains(t, out, "Content-Length: 98309\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.Zero(t, written)
+}
+
+func TestFileServerListing(t *testing.T) {
+	fileServer := NewFS(testFS)
+	fileServer.Listing = ListingOn
+
+	// Test: HTML listing escapes names and links
+	resp, body, err := serve(t, fileServer.Serve, "/docs/", "")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, `<a href="a%20&amp;%20b.txt">a &amp; b.txt</a>`)
+	assert.Contains(t, body, `<a href="sub/">sub/</a>`)
+	assert.Contains(t, body, "&lt;script&gt;.html")
+	assert.NotContains(t, body, "<script>")
+
+	// Test: JSON listing for clients that ask for it
+	resp, body, err = serve(t, fileServer.Serve, "/docs/", "Accept: application/json\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var entries []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &entries))
+	require.Len(t, entries, 3)
+	assert.Equal(t, "<script>.html", entries[0]["name"])
+	assert.Equal(t, "a & b.txt", entries[1]["name"])
+	assert.Equal(t, float64(2), entries[1]["size"])
+	assert.Equal(t, true, entries[2]["isDir"])
+
+	// Test: Index file still wins over the listing
+	_, body, err = serve(t, fileServer.Serve, "/site/", "")
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>index</h1>", body)
+
+	// Test: HEAD gets the listing headers without the body
+	out, written := serveHead(t, fileServer.Serve, "/docs/")
+	assert.Contains(t, out, "Content-Type: text/html; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.Zero(t, written)
+}
+
+func TestFileServerPathParam(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "clip.mp4"), []byte("ftyp"), 0o644))
+	fileServer := New(dir)
+	fileServer.PathParam = "path"
+
+	// Test: File path comes from the path parameter
+	handler := func(w *response.Writer, req *request.Request) error {
+		req.PathParams = map[string]string{"path": strings.TrimPrefix(req.RequestLine.RequestTarget, "/static/")}
+		return fileServer.Serve(w, req)
+	}
+	resp, body, err := serve(t, handler, "/static/clip.mp4", "")
+	require.NoError(t, err)
+	assert.Equal(t, "video/mp4", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "ftyp", body)
+
+	// Test: Directory named by an empty parameter is redirected to its slash form
+	handler = func(w *response.Writer, req *request.Request) error {
+		req.PathParams = map[string]string{"path": ""}
+		return fileServer.Serve(w, req)
+	}
+	resp, _, err = serve(t, handler, "/static?x=1", "")
+	require.NoError(t, err)
+	assert.Equal(t, 308, resp.StatusCode)
+	assert.Equal(t, "/static/?x=1", resp.Header.Get("Location"))
+
+	// Test: Directories behind a router get their slash form
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	r := router.New()
+	r.Handle("GET /static/{path...}", fileServer.Serve)
+	for target, location := range map[string]string{
+		"/static":     "/static/",
+		"/static/sub": "/static/sub/",
+	} {
+		resp, _, err = serve(t, r.Serve, target, "")
+		require.NoError(t, err)
+		assert.Equal(t, 308, resp.StatusCode, target)
+		assert.Equal(t, location, resp.Header.Get("Location"), target)
+	}
+	fileServer.Listing = ListingOn
+	_, body, err = serve(t, r.Serve, "/static/", "")
+	require.NoError(t, err)
+	assert.Contains(t, body, `<a href="sub/">sub/</a>`)
+
+	// Test: ServeFile serves a single named file
+	resp, body, err = serve(t, func(w *response.Writer, req *request.Request) error {
+		return ServeFile(w, req, filepath.Join(dir, "clip.mp4"))
+	}, "/video", "")
+	require.NoError(t, err)
+	assert.Equal(t, "video/mp4", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "ftyp", body)
+
+	_, _, err = serve(t, func(w *response.Writer, req *request.Request) error {
+		return ServeFile(w, req, filepath.Join(dir, "missing.mp4"))
+	}, "/video", "")
+	requireStatus(t, err, response.StatusNotFound)
+}
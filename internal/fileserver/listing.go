@@ -0,0 +1,105 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+)
+
+// listingEntry describes one directory entry in a listing.
+type listingEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// URL is the entry's link relative to the directory.
+	URL string `json:"-"`
+}
+
+var listingPage = htmltemplate.Must(htmltemplate.New("listing").Parse(`<html>
+  <head>
+    <title>Index of {{.Path}}</title>
+  </head>
+  <body>
+    <h1>Index of {{.Path}}</h1>
+    <ul>
+{{- range .Entries}}
+      <li><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></li>
+{{- end}}
+    </ul>
+  </body>
+</html>
+`))
+
+// serveListing lists the directory name, as JSON if the client prefers it
+// and as HTML otherwise.
+func serveListing(w *response.Writer, req *request.Request, fsys fs.FS, name, urlPath string) error {
+	dirEntries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return notFound(err)
+	}
+	entries := make([]listingEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entry := listingEntry{
+			Name:    dirEntry.Name(),
+			IsDir:   dirEntry.IsDir(),
+			ModTime: info.ModTime().UTC(),
+			URL:     url.PathEscape(dirEntry.Name()),
+		}
+		if entry.IsDir {
+			entry.URL += "/"
+		} else {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	var body bytes.Buffer
+	mediaType := "text/html; charset=utf-8"
+	if prefersJSON(req.Headers) {
+		mediaType = "application/json"
+		err = json.NewEncoder(&body).Encode(entries)
+	} else {
+		err = listingPage.Execute(&body, struct {
+			Path    string
+			Entries []listingEntry
+		}{urlPath, entries})
+	}
+	if err != nil {
+		return err
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", mediaType)
+	h.Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteStatusLine(response.StatusOK)
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if isHead(req) {
+		return nil
+	}
+	_, err = w.WriteBody(body.Bytes())
+	return err
+}
+
+// prefersJSON reports whether the Accept header ranks JSON above HTML.
+func prefersJSON(h *headers.Headers) bool {
+	accepted := h.Accept("Accept")
+	return headers.MediaTypeQuality(accepted, "application/json") > headers.MediaTypeQuality(accepted, "text/html")
+}
@@ -0,0 +1,111 @@
+package fileserver
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is how many leading bytes are inspected to guess a file's type.
+const sniffLen = 512
+
+// extensionTypes covers common extensions that the mime package does not
+// know on every system.
+var extensionTypes = map[string]string{
+	".css":  "text/css; charset=utf-8",
+	".csv":  "text/csv; charset=utf-8",
+	".gif":  "image/gif",
+	".htm":  "text/html; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".ico":  "image/x-icon",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".js":   "text/javascript; charset=utf-8",
+	".json": "application/json",
+	".md":   "text/markdown; charset=utf-8",
+	".mjs":  "text/javascript; charset=utf-8",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".txt":  "text/plain; charset=utf-8",
+	".wasm": "application/wasm",
+	".webm": "video/webm",
+	".webp": "image/webp",
+	".xml":  "text/xml; charset=utf-8",
+	".zip":  "application/zip",
+}
+
+type signature struct {
+	offset    int
+	prefix    []byte
+	mediaType string
+}
+
+// signatures are matched against the start of files whose extension is not
+// recognized.
+var signatures = []signature{
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{0, []byte("PK\x03\x04"), "application/zip"},
+	{0, []byte("\x1f\x8b\x08"), "application/gzip"},
+	{0, []byte("\x1a\x45\xdf\xa3"), "video/webm"},
+	{0, []byte("OggS"), "application/ogg"},
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("\x00asm"), "application/wasm"},
+	{4, []byte("ftyp"), "video/mp4"},
+}
+
+// contentType picks the media type of a file from its name, falling back to
+// sniffing head, the first bytes of its content.
+func contentType(name string, head []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	if mediaType, ok := extensionTypes[ext]; ok {
+		return mediaType
+	}
+	if mediaType := mime.TypeByExtension(ext); ext != "" && mediaType != "" {
+		return mediaType
+	}
+	return sniff(head)
+}
+
+func sniff(head []byte) string {
+	for _, sig := range signatures {
+		if len(head) >= sig.offset+len(sig.prefix) && bytes.Equal(head[sig.offset:sig.offset+len(sig.prefix)], sig.prefix) {
+			return sig.mediaType
+		}
+	}
+
+	trimmed := bytes.ToLower(bytes.TrimLeft(head, " \t\r\n"))
+	for _, tag := range []string{"<!doctype html", "<html", "<head", "<body"} {
+		if bytes.HasPrefix(trimmed, []byte(tag)) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if isText(head) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether head looks like UTF-8 text. A multi-byte character
+// cut off at the end of head does not count against it.
+func isText(head []byte) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			return len(head) < utf8.UTFMax && !utf8.FullRune(head)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+		head = head[size:]
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package fileserver
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxRanges bounds how many ranges a single request may ask for. Requests
+// for more are answered with the whole content.
+const maxRanges = 100
+
+var ErrorMalformedRange = fmt.Errorf("malformed range")
+var ErrorUnsatisfiableRange = fmt.Errorf("range not satisfiable")
+
+// byteRange is a satisfiable range of bytes within content of a known size.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value as defined in RFC 9110 section
+// 14.1.2 against content of the given size. Ranges that start past the end
+// are dropped; if none remain it returns ErrorUnsatisfiableRange. Overlapping
+// and adjacent ranges are merged. A header that is malformed, uses a unit
+// other than bytes, asks for too many ranges or for more bytes in total than
+// the content holds returns ErrorMalformedRange and should be ignored.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, ErrorMalformedRange
+	}
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, ErrorMalformedRange
+	}
+
+	ranges := []byteRange{}
+	empty := true
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		empty = false
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ErrorMalformedRange
+		}
+
+		if first == "" {
+			// Suffix range: the last n bytes.
+			n, err := parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		start, err := parseRangeInt(first)
+		if err != nil {
+			return nil, err
+		}
+		end := size - 1
+		if last != "" {
+			end, err = parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, ErrorMalformedRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+	if empty {
+		return nil, ErrorMalformedRange
+	}
+	if len(ranges) == 0 {
+		return nil, ErrorUnsatisfiableRange
+	}
+	// Asking for the same bytes over and over would make a small request
+	// cost many times the content, so such sets are answered with it once.
+	total := int64(0)
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		return nil, ErrorMalformedRange
+	}
+	return mergeRanges(ranges), nil
+}
+
+// mergeRanges coalesces ranges that overlap or touch. A merged range takes
+// the place of the last of its pieces; the others keep their order.
+func mergeRanges(ranges []byteRange) []byteRange {
+	merged := make([]byteRange, 0, len(ranges))
+	for _, r := range ranges {
+		for i := 0; i < len(merged); {
+			other := merged[i]
+			if other.start > r.start+r.length || r.start > other.start+other.length {
+				i++
+				continue
+			}
+			end := max(r.start+r.length, other.start+other.length)
+			r.start = min(r.start, other.start)
+			r.length = end - r.start
+			merged = slices.Delete(merged, i, i+1)
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func parseRangeInt(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ErrorMalformedRange
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrorMalformedRange
+	}
+	return n, nil
+}
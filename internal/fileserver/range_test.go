@@ -0,0 +1,86 @@
+package fileserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: Closed, open-ended and suffix ranges
+	ranges, err := parseRange("bytes=0-4", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 5}}, ranges)
+
+	ranges, err = parseRange("bytes=90-", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{90, 10}}, ranges)
+
+	ranges, err = parseRange("bytes=-10", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{90, 10}}, ranges)
+
+	// Test: Ends past the content are clamped
+	ranges, err = parseRange("bytes=95-200", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{95, 5}}, ranges)
+	ranges, err = parseRange("bytes=-500", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 100}}, ranges)
+
+	// Test: Several ranges with whitespace and empty elements
+	ranges, err = parseRange("bytes= 0-0 , ,10-19", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 1}, {10, 10}}, ranges)
+
+	// Test: Ranges starting past the end are dropped, none left is unsatisfiable
+	ranges, err = parseRange("bytes=100-, 0-1", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 2}}, ranges)
+	_, err = parseRange("bytes=100-200", 100)
+	assert.ErrorIs(t, err, ErrorUnsatisfiableRange)
+	_, err = parseRange("bytes=-0", 100)
+	assert.ErrorIs(t, err, ErrorUnsatisfiableRange)
+	_, err = parseRange("bytes=0-", 0)
+	assert.ErrorIs(t, err, ErrorUnsatisfiableRange)
+
+	// Test: Overlapping and adjacent ranges are merged
+	ranges, err = parseRange("bytes=50-59, 0-4, 55-64, 5-9", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{50, 15}, {0, 10}}, ranges)
+	ranges, err = parseRange("bytes=0-9, 20-29, 5-24", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 30}}, ranges)
+
+	// Test: Ranges adding up to more than the content are ignored
+	_, err = parseRange("bytes=0-,0-", 100)
+	assert.ErrorIs(t, err, ErrorMalformedRange)
+	_, err = parseRange("bytes=0-59, 40-99", 100)
+	assert.ErrorIs(t, err, ErrorMalformedRange)
+
+	// Test: Malformed headers
+	for _, header := range []string{
+		"bytes",
+		"items=0-1",
+		"bytes=",
+		"bytes=5",
+		"bytes=5-1",
+		"bytes=a-b",
+		"bytes=+1-2",
+		"bytes=0x1-2",
+		"bytes=--5",
+		"bytes=99999999999999999999-",
+	} {
+		_, err = parseRange(header, 100)
+		assert.ErrorIs(t, err, ErrorMalformedRange, header)
+	}
+
+	// Test: Too many ranges are ignored
+	header := "bytes=0-0"
+	for i := 0; i < maxRanges; i++ {
+		header += ",0-0"
+	}
+	_, err = parseRange(header, 100)
+	assert.ErrorIs(t, err, ErrorMalformedRange)
+}
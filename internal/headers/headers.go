@@ -6,44 +6,65 @@ import (
 	"strings"
 )
 
+// Headers holds header fields in the order they were added, each with the
+// name as it was given. Names are matched case-insensitively.
 type Headers struct {
-	headers map[string]string
+	fields []field
+}
+
+type field struct {
+	name  string
+	value string
 }
 
 var SEPARATOR = []byte("\r\n")
 var ErrorMalformedHeader = fmt.Errorf("malformed header")
 var ErrorMalformedHeaderKey = fmt.Errorf("malformed header key")
 var ErrorMalformedHeaderValue = fmt.Errorf("malformed header value")
+var ErrorObsoleteLineFolding = fmt.Errorf("obsolete line folding")
 
 func parseHeader(data []byte) (string, string, error) {
+	// A line starting with whitespace continues the previous field in the
+	// obsolete folded syntax, which intermediaries disagree on.
+	if len(data) > 0 && isWhitespace(data[0]) {
+		return "", "", ErrorObsoleteLineFolding
+	}
+
 	parts := bytes.SplitN(data, []byte(":"), 2)
 	if len(parts) != 2 {
 		return "", "", ErrorMalformedHeader
 	}
 	name := parts[0]
 	value := bytes.TrimSpace(parts[1])
-
-	// Check for trailing space before colon (invalid per HTTP spec)
-	if bytes.HasSuffix(name, []byte(" ")) {
+	if len(name) == 0 {
 		return "", "", ErrorMalformedHeaderKey
 	}
 
-	// Trim leading spaces and validate
-	name = bytes.TrimSpace(name)
-	if len(name) == 0 {
+	// Whitespace before the colon is invalid per HTTP spec
+	if isWhitespace(name[len(name)-1]) {
 		return "", "", ErrorMalformedHeaderKey
 	}
 
+	// A bare CR or LF could end the line early for another parser
+	if bytes.ContainsAny(value, "\r\n\x00") {
+		return "", "", ErrorMalformedHeaderValue
+	}
+
 	return string(name), string(value), nil
 }
 
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
 var validTokenChars = map[byte]bool{
 	'!': true, '#': true, '$': true, '%': true, '&': true,
 	'\'': true, '*': true, '+': true, '-': true, '.': true,
 	'^': true, '_': true, '`': true, '|': true, '~': true,
 }
 
-func isToken(str []byte) bool {
+// IsToken reports whether str is a non-empty RFC 9110 token.
+func IsToken(str []byte) bool {
 	if len(str) == 0 {
 		return false
 	}
@@ -56,35 +77,99 @@ func isToken(str []byte) bool {
 }
 
 func NewHeaders() *Headers {
-	return &Headers{
-		headers: map[string]string{},
-	}
+	return &Headers{}
 }
 
+// Get returns the values of the named field joined with commas, which is
+// equivalent for every field except Set-Cookie. Use Values for that.
 func (h *Headers) Get(name string) (string, bool) {
-	value, exists := h.headers[strings.ToLower(name)]
-	return value, exists
+	values := h.Values(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return strings.Join(values, ","), true
+}
+
+// Values returns the value of each field line with the given name, in order.
+func (h *Headers) Values(name string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, name) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Add appends a field line, even if the name is already present.
+func (h *Headers) Add(name, value string) {
+	h.fields = append(h.fields, field{name: name, value: value})
 }
 
+// Set appends value to the list held by the first field with the same name,
+// or adds the field if there is none. Set-Cookie values cannot be combined,
+// so they always get a line of their own.
 func (h *Headers) Set(name, value string) {
-	if _, exists := h.headers[strings.ToLower(name)]; exists {
-		h.headers[strings.ToLower(name)] += "," + value
+	if strings.EqualFold(name, "Set-Cookie") {
+		h.Add(name, value)
 		return
 	}
-	h.headers[strings.ToLower(name)] = value
+	for i := range h.fields {
+		if strings.EqualFold(h.fields[i].name, name) {
+			h.fields[i].value += "," + value
+			return
+		}
+	}
+	h.Add(name, value)
 }
 
+// Replace sets the named field to value, keeping the position of the first
+// existing line and dropping any others.
 func (h *Headers) Replace(name, value string) {
-	h.headers[strings.ToLower(name)] = value
+	replaced := false
+	fields := h.fields[:0]
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, name) {
+			if replaced {
+				continue
+			}
+			f = field{name: name, value: value}
+			replaced = true
+		}
+		fields = append(fields, f)
+	}
+	h.fields = fields
+	if !replaced {
+		h.Add(name, value)
+	}
 }
 
 func (h *Headers) Delete(name string) {
-	delete(h.headers, strings.ToLower(name))
+	fields := h.fields[:0]
+	for _, f := range h.fields {
+		if !strings.EqualFold(f.name, name) {
+			fields = append(fields, f)
+		}
+	}
+	h.fields = fields
+}
+
+// HasToken reports whether the comma-separated value of the named header
+// contains token, compared case-insensitively.
+func (h *Headers) HasToken(name, token string) bool {
+	for _, t := range h.Tokens(name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
 }
 
+// ForEach calls callback for every field line in order, with the name as it
+// was added.
 func (h *Headers) ForEach(callback func(name, value string)) {
-	for name, value := range h.headers {
-		callback(name, value)
+	for _, f := range h.fields {
+		callback(f.name, f.value)
 	}
 }
 
@@ -106,10 +191,10 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 		if err != nil {
 			return 0, false, err
 		}
-		if !isToken([]byte(name)) {
+		if !IsToken([]byte(name)) {
 			return 0, false, ErrorMalformedHeaderKey
 		}
-		h.Set(name, value)
+		h.Add(name, value)
 		read += idx + len(SEPARATOR)
 	}
 	return read, done, nil
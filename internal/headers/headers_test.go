@@ -48,3 +48,84 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 48, n)
 	assert.True(t, done)
 }
+
+func TestHeadersParseStrict(t *testing.T) {
+	// Test: Tab before the colon
+	headers := NewHeaders()
+	n, done, err := headers.Parse([]byte("Content-Length\t: 5\r\n\r\n"))
+	require.ErrorIs(t, err, ErrorMalformedHeaderKey)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Folded continuation line
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("Host: localhost\r\n Transfer-Encoding: chunked\r\n\r\n"))
+	require.ErrorIs(t, err, ErrorObsoleteLineFolding)
+	_, _, err = headers.Parse([]byte("\tHost: localhost\r\n\r\n"))
+	require.ErrorIs(t, err, ErrorObsoleteLineFolding)
+
+	// Test: Bare CR, bare LF and NUL in a value
+	for _, value := range []string{"a\rb", "a\nb", "a\x00b"} {
+		headers = NewHeaders()
+		_, _, err = headers.Parse([]byte("X-Test: " + value + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrorMalformedHeaderValue)
+	}
+
+	// Test: Whitespace around the value is still trimmed
+	headers = NewHeaders()
+	_, done, err = headers.Parse([]byte("Host:\t localhost:42069 \t\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, done)
+	value, _ := headers.Get("Host")
+	assert.Equal(t, "localhost:42069", value)
+}
+
+func TestHeadersOrder(t *testing.T) {
+	collect := func(h *Headers) []string {
+		lines := []string{}
+		h.ForEach(func(name, value string) {
+			lines = append(lines, name+": "+value)
+		})
+		return lines
+	}
+
+	// Test: Parsed fields keep their order, casing and separate lines
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("Host: localhost\r\nX-Trace: a\r\ncookie: x=1\r\nX-TRACE: b\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Host: localhost", "X-Trace: a", "cookie: x=1", "X-TRACE: b"}, collect(headers))
+	assert.Equal(t, []string{"a", "b"}, headers.Values("x-trace"))
+	value, _ := headers.Get("X-Trace")
+	assert.Equal(t, "a,b", value)
+	assert.Nil(t, headers.Values("Missing"))
+
+	// Test: Set combines values into the first line, Add starts a new one
+	headers = NewHeaders()
+	headers.Set("Vary", "Accept")
+	headers.Set("Content-Type", "text/plain")
+	headers.Set("vary", "Accept-Encoding")
+	headers.Add("Vary", "Origin")
+	assert.Equal(t, []string{"Vary: Accept,Accept-Encoding", "Content-Type: text/plain", "Vary: Origin"}, collect(headers))
+	assert.True(t, headers.HasToken("Vary", "origin"))
+
+	// Test: Set-Cookie values are never combined
+	headers = NewHeaders()
+	headers.Set("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	headers.Set("Set-Cookie", "b=2")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, headers.Values("set-cookie"))
+
+	// Test: Replace keeps the first position and drops the other lines
+	headers = NewHeaders()
+	headers.Add("A", "1")
+	headers.Add("B", "2")
+	headers.Add("a", "3")
+	headers.Replace("A", "4")
+	assert.Equal(t, []string{"A: 4", "B: 2"}, collect(headers))
+	headers.Replace("C", "5")
+	assert.Equal(t, []string{"A: 4", "B: 2", "C: 5"}, collect(headers))
+
+	// Test: Delete removes every line with the name
+	headers.Add("b", "6")
+	headers.Delete("B")
+	assert.Equal(t, []string{"A: 4", "C: 5"}, collect(headers))
+}
@@ -0,0 +1,319 @@
+package headers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrorInvalidContentLength = fmt.Errorf("invalid Content-Length")
+var ErrorMalformedMediaType = fmt.Errorf("malformed media type")
+var ErrorMalformedQuotedString = fmt.Errorf("malformed quoted string")
+var ErrorMalformedTime = fmt.Errorf("malformed HTTP date")
+
+// TimeFormat is the IMF-fixdate format used for HTTP dates.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsoleteTimeFormats are the RFC 850 and asctime formats recipients must
+// still accept.
+var obsoleteTimeFormats = []string{"Monday, 02-Jan-06 15:04:05 GMT", "Mon Jan _2 15:04:05 2006"}
+
+// ContentLength returns the Content-Length, or -1 if there is none. Repeated
+// values are accepted only if they all agree, and anything but digits is
+// an error.
+func (h *Headers) ContentLength() (int64, error) {
+	values := h.Values("Content-Length")
+	if len(values) == 0 {
+		return -1, nil
+	}
+	length := int64(-1)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" || strings.Trim(part, "0123456789") != "" {
+				return 0, ErrorInvalidContentLength
+			}
+			n, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return 0, ErrorInvalidContentLength
+			}
+			if length != -1 && n != length {
+				return 0, ErrorInvalidContentLength
+			}
+			length = n
+		}
+	}
+	return length, nil
+}
+
+// ContentType returns the media type of the Content-Type field, lowercased,
+// and its parameters. It returns "" if the field is missing.
+func (h *Headers) ContentType() (string, map[string]string, error) {
+	value, ok := h.Get("Content-Type")
+	if !ok {
+		return "", nil, nil
+	}
+	return ParseMediaType(value)
+}
+
+// ParseMediaType parses a value such as `text/html; charset="utf-8"`. The
+// type, subtype and parameter names are lowercased and quoted parameter
+// values are unquoted.
+func ParseMediaType(value string) (string, map[string]string, error) {
+	mediaType, rest, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	mainType, subType, ok := strings.Cut(mediaType, "/")
+	if !ok || !IsToken([]byte(mainType)) || !IsToken([]byte(subType)) {
+		return "", nil, ErrorMalformedMediaType
+	}
+	params, err := parseParams(rest)
+	if err != nil {
+		return "", nil, err
+	}
+	return mediaType, params, nil
+}
+
+// parseParams parses the ";"-separated name=value pairs that follow a media
+// type or list element.
+func parseParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t;")
+		if s == "" {
+			return params, nil
+		}
+		end := strings.IndexAny(s, "=;")
+		if end == -1 || s[end] != '=' {
+			return nil, ErrorMalformedMediaType
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:end]))
+		if !IsToken([]byte(name)) {
+			return nil, ErrorMalformedMediaType
+		}
+		s = strings.TrimLeft(s[end+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			n := quotedStringLen(s)
+			if n == -1 {
+				return nil, ErrorMalformedQuotedString
+			}
+			value, _ = Unquote(s[:n])
+			s = s[n:]
+		} else {
+			end = strings.IndexByte(s, ';')
+			if end == -1 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		if rest := strings.TrimLeft(s, " \t"); rest != "" && rest[0] != ';' {
+			return nil, ErrorMalformedMediaType
+		}
+		params[name] = value
+	}
+}
+
+// quotedStringLen returns the length of the quoted string at the start of
+// s, quotes included, or -1 if it is not terminated.
+func quotedStringLen(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// Quote returns s as a quoted string, escaping quotes and backslashes.
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Unquote returns the content of a quoted string, resolving backslash
+// escapes.
+func Unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || quotedStringLen(s) != len(s) {
+		return "", ErrorMalformedQuotedString
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+// SplitList splits a comma-separated field value into its trimmed elements,
+// skipping empty ones. Commas inside quoted strings do not split.
+func SplitList(value string) []string {
+	elements := []string{}
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			if n := quotedStringLen(value[i:]); n != -1 {
+				i += n - 1
+			}
+		case ',':
+			elements = appendElement(elements, value[start:i])
+			start = i + 1
+		}
+	}
+	return appendElement(elements, value[start:])
+}
+
+func appendElement(elements []string, element string) []string {
+	if element = strings.TrimSpace(element); element != "" {
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+// Tokens returns the elements of every line of the named list field,
+// lowercased, which suits fields such as Connection and Content-Encoding.
+func (h *Headers) Tokens(name string) []string {
+	tokens := []string{}
+	for _, value := range h.Values(name) {
+		for _, element := range SplitList(value) {
+			tokens = append(tokens, strings.ToLower(element))
+		}
+	}
+	return tokens
+}
+
+// ParseTime parses an HTTP date in the IMF-fixdate format or one of the
+// obsolete formats, and returns it in UTC.
+func ParseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range append([]string{TimeFormat}, obsoleteTimeFormats...) {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, ErrorMalformedTime
+}
+
+// FormatTime formats t as an IMF-fixdate.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// Time returns the named date field. It reports false if the field is
+// missing or is not a valid HTTP date.
+func (h *Headers) Time(name string) (time.Time, bool) {
+	value, ok := h.Get(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := ParseTime(value)
+	return t, err == nil
+}
+
+// SetTime sets the named field to t as an IMF-fixdate.
+func (h *Headers) SetTime(name string, t time.Time) {
+	h.Replace(name, FormatTime(t))
+}
+
+// QualityValue is an element of an Accept, Accept-Encoding, Accept-Charset
+// or Accept-Language field.
+type QualityValue struct {
+	// Value is the media range, coding, charset or language, lowercased.
+	Value string
+	// Params holds the parameters other than q.
+	Params map[string]string
+	Q      float64
+}
+
+// ParseAccept parses an Accept-style field value, ordered from the highest
+// q-value to the lowest. Elements with equal q-values keep their order. An
+// invalid q-value counts as 0, which means "not acceptable".
+func ParseAccept(value string) []QualityValue {
+	values := []QualityValue{}
+	for _, element := range SplitList(value) {
+		v, rest, _ := strings.Cut(element, ";")
+		qv := QualityValue{Value: strings.ToLower(strings.TrimSpace(v)), Params: map[string]string{}, Q: 1}
+		if qv.Value == "" {
+			continue
+		}
+		params, err := parseParams(rest)
+		if err != nil {
+			qv.Q = 0
+		}
+		for name, param := range params {
+			if name != "q" {
+				qv.Params[name] = param
+				continue
+			}
+			q, err := strconv.ParseFloat(param, 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			qv.Q = q
+		}
+		values = append(values, qv)
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].Q > values[j].Q
+	})
+	return values
+}
+
+// Accept parses every line of the named Accept-style field.
+func (h *Headers) Accept(name string) []QualityValue {
+	values, _ := h.Get(name)
+	return ParseAccept(values)
+}
+
+// Quality returns the q-value accepted gives value, falling back to a "*"
+// element. It reports false if neither is listed.
+func Quality(accepted []QualityValue, value string) (float64, bool) {
+	for _, wildcard := range []bool{false, true} {
+		for _, qv := range accepted {
+			if (!wildcard && strings.EqualFold(qv.Value, value)) || (wildcard && qv.Value == "*") {
+				return qv.Q, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// MediaTypeQuality returns the q-value accepted gives mediaType, using the
+// most specific matching media range, or 0 if none matches.
+func MediaTypeQuality(accepted []QualityValue, mediaType string) float64 {
+	mediaType = strings.ToLower(mediaType)
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, qv := range accepted {
+		s := -1
+		switch qv.Value {
+		case mediaType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = qv.Q, s
+		}
+	}
+	return q
+}
@@ -0,0 +1,165 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func with(fields ...string) *Headers {
+	h := NewHeaders()
+	for i := 0; i < len(fields); i += 2 {
+		h.Add(fields[i], fields[i+1])
+	}
+	return h
+}
+
+func TestContentLength(t *testing.T) {
+	// Test: Missing field
+	n, err := with().ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), n)
+
+	// Test: Valid lengths, including repeats that agree
+	n, err = with("Content-Length", "42").ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+	n, err = with("Content-Length", "5", "content-length", "5, 5").ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = with("Content-Length", "9223372036854775807").ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(9223372036854775807), n)
+
+	// Test: Invalid lengths
+	for _, value := range []string{"", "-1", "+1", "0x10", "1.0", "1 2", "5,7", "9223372036854775808"} {
+		_, err = with("Content-Length", value).ContentLength()
+		assert.ErrorIs(t, err, ErrorInvalidContentLength, value)
+	}
+}
+
+func TestParseMediaType(t *testing.T) {
+	// Test: Type, subtype and parameter names are lowercased
+	mediaType, params, err := ParseMediaType(`Text/HTML; Charset=utf-8`)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", mediaType)
+	assert.Equal(t, map[string]string{"charset": "utf-8"}, params)
+
+	// Test: Quoted parameter values may hold separators and escapes
+	mediaType, params, err = ParseMediaType(`multipart/form-data ; boundary="a;b, \"c\"" ;x=1`)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+	assert.Equal(t, map[string]string{"boundary": `a;b, "c"`, "x": "1"}, params)
+
+	// Test: Malformed values
+	for _, value := range []string{"", "text", "text/", "/html", "te xt/html", "text/html; charset", `text/html; a="b`, `text/html; a="b"c`} {
+		_, _, err = ParseMediaType(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test: Content-Type accessor
+	mediaType, params, err = with("Content-Type", "application/json").ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", mediaType)
+	assert.Empty(t, params)
+	mediaType, _, err = with().ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "", mediaType)
+}
+
+func TestQuote(t *testing.T) {
+	// Test: Round trip through Quote and Unquote
+	for _, s := range []string{"", "plain", `say "hi"`, `back\slash`, "a, b; c"} {
+		quoted := Quote(s)
+		unquoted, err := Unquote(quoted)
+		require.NoError(t, err)
+		assert.Equal(t, s, unquoted)
+	}
+	assert.Equal(t, `"say \"hi\""`, Quote(`say "hi"`))
+
+	// Test: Malformed quoted strings
+	for _, s := range []string{``, `"`, `abc`, `"abc`, `"a"b"`, `"abc\"`} {
+		_, err := Unquote(s)
+		assert.ErrorIs(t, err, ErrorMalformedQuotedString, s)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	// Test: Elements from every line, lowercased, empty ones skipped
+	h := with("Connection", "Keep-Alive, , Upgrade", "connection", "close,")
+	assert.Equal(t, []string{"keep-alive", "upgrade", "close"}, h.Tokens("Connection"))
+	assert.True(t, h.HasToken("Connection", "UPGRADE"))
+	assert.Empty(t, h.Tokens("Missing"))
+
+	// Test: Commas inside quoted strings do not split
+	assert.Equal(t, []string{`W/"a,b"`, `"c"`}, SplitList(` W/"a,b" ,"c"`))
+}
+
+func TestTime(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	// Test: IMF-fixdate and the obsolete formats
+	for _, value := range []string{"Sun, 06 Nov 1994 08:49:37 GMT", "Sunday, 06-Nov-94 08:49:37 GMT", "Sun Nov  6 08:49:37 1994"} {
+		got, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(got), value)
+	}
+	_, err := ParseTime("yesterday")
+	assert.ErrorIs(t, err, ErrorMalformedTime)
+
+	// Test: Times are formatted in GMT
+	local := want.In(time.FixedZone("CET", 3600))
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatTime(local))
+
+	// Test: Field accessors
+	h := NewHeaders()
+	h.SetTime("Last-Modified", local)
+	got, ok := h.Time("last-modified")
+	assert.True(t, ok)
+	assert.True(t, want.Equal(got))
+	_, ok = with("Date", "not a date").Time("Date")
+	assert.False(t, ok)
+	_, ok = h.Time("Date")
+	assert.False(t, ok)
+}
+
+func TestParseAccept(t *testing.T) {
+	// Test: Elements are ordered by q-value, keeping ties in order
+	accepted := ParseAccept(`text/html;level=1, application/json;q=0.9, text/*;q=0.9, */*;q=0.1, image/png;q=1.0`)
+	values := []string{}
+	for _, qv := range accepted {
+		values = append(values, qv.Value)
+	}
+	assert.Equal(t, []string{"text/html", "image/png", "application/json", "text/*", "*/*"}, values)
+	assert.Equal(t, map[string]string{"level": "1"}, accepted[0].Params)
+	assert.Equal(t, 0.9, accepted[2].Q)
+
+	// Test: Invalid q-values mean not acceptable
+	for _, value := range []string{"gzip;q=2", "gzip;q=-1", "gzip;q=abc", "gzip;q"} {
+		accepted = ParseAccept(value)
+		require.Len(t, accepted, 1, value)
+		assert.Equal(t, 0.0, accepted[0].Q, value)
+	}
+
+	// Test: Quality falls back to the wildcard
+	accepted = ParseAccept("gzip;q=0.5, *;q=0.2, br;q=0")
+	q, ok := Quality(accepted, "GZIP")
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, q)
+	q, ok = Quality(accepted, "br")
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, q)
+	q, _ = Quality(accepted, "deflate")
+	assert.Equal(t, 0.2, q)
+	_, ok = Quality(ParseAccept("gzip"), "deflate")
+	assert.False(t, ok)
+
+	// Test: Media types use the most specific matching range
+	accepted = with("Accept", "text/*;q=0.5, */*;q=0.1", "Accept", "text/html").Accept("Accept")
+	assert.Equal(t, 1.0, MediaTypeQuality(accepted, "text/html"))
+	assert.Equal(t, 0.5, MediaTypeQuality(accepted, "text/plain"))
+	assert.Equal(t, 0.1, MediaTypeQuality(accepted, "application/json"))
+	assert.Equal(t, 0.0, MediaTypeQuality(ParseAccept("text/html"), "application/json"))
+}
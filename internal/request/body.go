@@ -0,0 +1,73 @@
+package request
+
+import (
+	"fmt"
+	"io"
+)
+
+var ErrorBodyClosed = fmt.Errorf("read on closed request body")
+
+// body is the BodyReader of a streaming request. The request keeps being
+// parsed from the Reader's stream as the body is read, so Content-Length and
+// chunked framing, as well as the body size limit, are applied as usual.
+type body struct {
+	reader  *Reader
+	request *Request
+	err     error
+	closed  bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrorBodyClosed
+	}
+	if err := b.next(); err != nil {
+		return 0, err
+	}
+	n := copy(p, b.request.pending)
+	b.request.pending = b.request.pending[n:]
+	return n, nil
+}
+
+// next makes sure some decoded bytes are pending. It returns io.EOF once the
+// whole body has been read.
+func (b *body) next() error {
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.request.pending) > 0 {
+		return nil
+	}
+	// Reuse the pending buffer now that it has been read.
+	b.request.pending = b.request.pending[:0]
+	err := b.reader.fill(b.request, func() bool {
+		return len(b.request.pending) > 0 || b.request.done()
+	})
+	if err == nil && len(b.request.pending) == 0 {
+		err = io.EOF
+	}
+	if err != nil {
+		b.err = err
+	}
+	return err
+}
+
+// Close reads and discards the rest of the body, so the Reader is positioned
+// at the start of the next request. It returns the error that ended the body
+// early, if any, in which case the stream cannot be reused.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for {
+		b.request.pending = b.request.pending[:0]
+		err := b.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
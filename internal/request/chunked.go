@@ -0,0 +1,97 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"webserver/internal/headers"
+)
+
+var ErrorMalformedChunk = fmt.Errorf("malformed chunk")
+
+// maxChunkSizeDigits keeps the hexadecimal chunk size within an int.
+const maxChunkSizeDigits = 15
+
+// maxChunkLineBytes bounds a chunk-size line, extensions included.
+const maxChunkLineBytes = 4096
+
+func (r *Request) isChunkedState() bool {
+	switch r.state {
+	case StateChunkSize, StateChunkData, StateChunkDataEnd, StateTrailers:
+		return true
+	}
+	return false
+}
+
+// isChunked reports whether chunked is the only transfer coding. No other
+// coding is decoded, and chunked may not be applied twice.
+func isChunked(codings []string) bool {
+	return len(codings) == 1 && codings[0] == "chunked"
+}
+
+// parseChunkSize parses a chunk-size line, including any chunk extensions,
+// and returns the size along with the number of bytes consumed. It consumes
+// nothing until the whole line is available.
+func parseChunkSize(data []byte) (int, int, error) {
+	idx := bytes.Index(data, SEPARATOR)
+	if idx == -1 {
+		if len(data) > maxChunkLineBytes {
+			return 0, 0, ErrorMalformedChunk
+		}
+		return 0, 0, nil
+	}
+	if idx > maxChunkLineBytes {
+		return 0, 0, ErrorMalformedChunk
+	}
+	line := data[:idx]
+
+	sizePart, extensions, hasExtensions := bytes.Cut(line, []byte(";"))
+	sizePart = bytes.TrimRight(sizePart, " \t")
+	if len(sizePart) == 0 || len(sizePart) > maxChunkSizeDigits {
+		return 0, 0, ErrorMalformedChunk
+	}
+	size := 0
+	for _, c := range sizePart {
+		var digit byte
+		switch {
+		case c >= '0' && c <= '9':
+			digit = c - '0'
+		case c >= 'a' && c <= 'f':
+			digit = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			digit = c - 'A' + 10
+		default:
+			return 0, 0, ErrorMalformedChunk
+		}
+		size = size*16 + int(digit)
+	}
+
+	if hasExtensions {
+		if err := validateChunkExtensions(extensions); err != nil {
+			return 0, 0, err
+		}
+	}
+	return size, idx + len(SEPARATOR), nil
+}
+
+// validateChunkExtensions checks the syntax of chunk extensions. Their
+// meaning is not defined by HTTP, so valid extensions are otherwise ignored.
+func validateChunkExtensions(data []byte) error {
+	for _, ext := range bytes.Split(data, []byte(";")) {
+		name, value, hasValue := bytes.Cut(ext, []byte("="))
+		name = bytes.Trim(name, " \t")
+		if !headers.IsToken(name) {
+			return ErrorMalformedChunk
+		}
+		if !hasValue {
+			continue
+		}
+		value = bytes.Trim(value, " \t")
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			continue
+		}
+		if !headers.IsToken(value) {
+			return ErrorMalformedChunk
+		}
+	}
+	return nil
+}
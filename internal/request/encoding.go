@@ -0,0 +1,184 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+var ErrorUnsupportedContentEncoding = fmt.Errorf("unsupported content encoding")
+var ErrorMalformedContentEncoding = fmt.Errorf("malformed content encoding")
+var ErrorDecodedBodyTooLarge = fmt.Errorf("decoded request body too large")
+
+// maxContentCodings bounds how many codings a body may be wrapped in. Each
+// layer costs a decoder regardless of how small the decoded body is, so the
+// decoded size limit alone does not stop deeply nested bodies.
+const maxContentCodings = 2
+
+// contentCodings returns the codings listed in the request's
+// Content-Encoding, in the order they were applied, leaving out identity.
+// It fails for codings that cannot be decoded, for a coding applied twice
+// and for more than maxContentCodings codings.
+func (r *Request) contentCodings() ([]string, error) {
+	if _, ok := r.Headers.Get("Content-Encoding"); !ok {
+		return nil, nil
+	}
+	codings := []string{}
+	for _, coding := range r.Headers.Tokens("Content-Encoding") {
+		switch coding {
+		case "identity":
+		case "gzip", "x-gzip", "deflate":
+			if coding == "x-gzip" {
+				coding = "gzip"
+			}
+			if slices.Contains(codings, coding) {
+				return nil, fmt.Errorf("%w: %q applied twice", ErrorUnsupportedContentEncoding, coding)
+			}
+			codings = append(codings, coding)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrorUnsupportedContentEncoding, coding)
+		}
+		if len(codings) > maxContentCodings {
+			return nil, fmt.Errorf("%w: more than %d codings", ErrorUnsupportedContentEncoding, maxContentCodings)
+		}
+	}
+	return codings, nil
+}
+
+// decoder undoes codings, which were applied in order, on top of src.
+func decoder(src io.Reader, codings []string) (io.Reader, error) {
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch codings[i] {
+		case "gzip":
+			src, err = gzip.NewReader(src)
+		case "deflate":
+			src, err = deflateReader(src)
+		}
+		if errors.Is(err, io.EOF) {
+			// Every coding has a header, so an empty body is malformed.
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, decodeError(err)
+		}
+	}
+	return src, nil
+}
+
+// decodeError reports errors in the coded data as
+// ErrorMalformedContentEncoding and passes others, such as those from
+// reading the body, through.
+func decodeError(err error) error {
+	var corrupt flate.CorruptInputError
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, zlib.ErrHeader) || errors.Is(err, zlib.ErrChecksum) || errors.As(err, &corrupt) {
+		return fmt.Errorf("%w: %v", ErrorMalformedContentEncoding, err)
+	}
+	return err
+}
+
+// deflateReader reads the deflate coding, which is the zlib format. Some
+// clients send raw deflate data instead, which is accepted as well.
+func deflateReader(src io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(src)
+	header, err := buffered.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// decodedReader reads a decoded body, failing once it exceeds the limit and
+// turning decoding errors into ErrorMalformedContentEncoding.
+type decodedReader struct {
+	src   io.Reader
+	limit int
+	read  int
+}
+
+func (d *decodedReader) Read(p []byte) (int, error) {
+	if d.limit > 0 && len(p) > d.limit-d.read+1 {
+		// Read at most one byte past the limit to detect it.
+		p = p[:d.limit-d.read+1]
+	}
+	n, err := d.src.Read(p)
+	d.read += n
+	if d.limit > 0 && d.read > d.limit {
+		return n, ErrorDecodedBodyTooLarge
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = decodeError(err)
+	}
+	return n, err
+}
+
+// decodeBody replaces a buffered body with its decoded form.
+func (r *Request) decodeBody(codings []string) error {
+	src, err := decoder(bytes.NewReader(r.Body), codings)
+	if err != nil {
+		return err
+	}
+	decoded, err := io.ReadAll(&decodedReader{src: src, limit: r.limits.MaxDecodedBodyBytes})
+	if err != nil {
+		return err
+	}
+	r.Body = decoded
+	r.markDecoded(len(decoded))
+	return nil
+}
+
+// markDecoded updates the headers of a request whose body is handed out
+// decoded, so they describe what the handler reads. A buffered body is
+// described by its length alone, even if it arrived chunked, so the handler
+// never sees both framing fields.
+func (r *Request) markDecoded(length int) {
+	r.Headers.Delete("Content-Encoding")
+	if length >= 0 {
+		r.Headers.Delete("Transfer-Encoding")
+		r.Headers.Replace("Content-Length", strconv.Itoa(length))
+	} else {
+		r.Headers.Delete("Content-Length")
+	}
+}
+
+// decodingBody is the BodyReader of a streaming request whose body is
+// decoded as it is read. The decoders are only set up on the first Read,
+// since that already reads from the body. Closing it drains the undecoded
+// body.
+type decodingBody struct {
+	raw     io.ReadCloser
+	codings []string
+	limit   int
+	decoded io.Reader
+	err     error
+}
+
+func (b *decodingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.decoded == nil {
+		src, err := decoder(b.raw, b.codings)
+		if err != nil {
+			b.err = err
+			return 0, err
+		}
+		b.decoded = &decodedReader{src: src, limit: b.limit}
+	}
+	return b.decoded.Read(p)
+}
+
+func (b *decodingBody) Close() error {
+	return b.raw.Close()
+}
@@ -0,0 +1,38 @@
+package request
+
+import (
+	"fmt"
+	"math"
+	"webserver/internal/headers"
+)
+
+var ErrorConflictingFraming = fmt.Errorf("both Content-Length and Transfer-Encoding are set")
+var ErrorInvalidContentLength = headers.ErrorInvalidContentLength
+
+// setFraming decides how the body is delimited, following RFC 9112 section
+// 6.3. Anything a front-end proxy could read differently is rejected rather
+// than guessed at, since the two disagreeing on where a request ends is what
+// request smuggling relies on.
+func (r *Request) setFraming() error {
+	_, hasTransferEncoding := r.Headers.Get("Transfer-Encoding")
+	_, hasContentLength := r.Headers.Get("Content-Length")
+	if hasTransferEncoding && hasContentLength {
+		return ErrorConflictingFraming
+	}
+	if hasTransferEncoding {
+		if !isChunked(r.Headers.Tokens("Transfer-Encoding")) {
+			return ErrorUnsupportedTransferEncoding
+		}
+		r.chunked = true
+		return nil
+	}
+	length, err := r.Headers.ContentLength()
+	if err != nil {
+		return err
+	}
+	if length > math.MaxInt {
+		return ErrorBodyTooLarge
+	}
+	r.contentLength = max(int(length), 0)
+	return nil
+}
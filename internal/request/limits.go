@@ -0,0 +1,86 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"webserver/internal/headers"
+)
+
+var ErrorRequestLineTooLong = fmt.Errorf("request line too long")
+var ErrorHeadersTooLarge = fmt.Errorf("request header fields too large")
+var ErrorBodyTooLarge = fmt.Errorf("request body too large")
+
+// Limits bounds how much of a request the parser accepts, so a client cannot
+// make the server buffer arbitrary amounts of data. Zero disables a limit.
+type Limits struct {
+	// MaxRequestLineBytes bounds the request line, excluding its CRLF.
+	MaxRequestLineBytes int
+	// MaxHeaderCount bounds the number of header lines, trailers included.
+	MaxHeaderCount int
+	// MaxHeaderBytes bounds the size of the header block, trailers included.
+	MaxHeaderBytes int
+	// MaxBodyBytes bounds the body as received, after undoing chunked
+	// framing.
+	MaxBodyBytes int
+	// MaxDecodedBodyBytes bounds the body after undoing its
+	// Content-Encoding, when the Reader decodes it.
+	MaxDecodedBodyBytes int
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderCount:      100,
+	MaxHeaderBytes:      64 << 10,
+	MaxBodyBytes:        10 << 20,
+	MaxDecodedBodyBytes: 50 << 20,
+}
+
+// checkRequestLine fails once the request line, complete or not, is longer
+// than allowed.
+func (r *Request) checkRequestLine(data []byte) error {
+	if r.limits.MaxRequestLineBytes <= 0 {
+		return nil
+	}
+	lineLen := bytes.Index(data, SEPARATOR)
+	if lineLen == -1 {
+		lineLen = len(data)
+	}
+	if lineLen > r.limits.MaxRequestLineBytes {
+		return ErrorRequestLineTooLong
+	}
+	return nil
+}
+
+// parseFields parses header or trailer lines into h while keeping count of
+// the field lines and bytes seen so far, including a line that is still
+// incomplete.
+func (r *Request) parseFields(h *headers.Headers, data []byte) (int, bool, error) {
+	n, done, err := h.Parse(data)
+	if err != nil {
+		return 0, false, err
+	}
+	r.fieldBytes += n
+	r.fieldCount += bytes.Count(data[:n], SEPARATOR)
+	pending := len(data) - n
+	if done {
+		// The empty line that ends the block is not a field.
+		r.fieldCount--
+		pending = 0
+	}
+
+	if r.limits.MaxHeaderBytes > 0 && r.fieldBytes+pending > r.limits.MaxHeaderBytes {
+		return 0, false, ErrorHeadersTooLarge
+	}
+	if r.limits.MaxHeaderCount > 0 && r.fieldCount > r.limits.MaxHeaderCount {
+		return 0, false, ErrorHeadersTooLarge
+	}
+	return n, done, nil
+}
+
+// checkBodySize fails if a body of size bytes would exceed the limit.
+func (r *Request) checkBodySize(size int) error {
+	if r.limits.MaxBodyBytes > 0 && size > r.limits.MaxBodyBytes {
+		return ErrorBodyTooLarge
+	}
+	return nil
+}
@@ -2,10 +2,10 @@ package request
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
 	"webserver/internal/headers"
 )
 
@@ -21,25 +21,43 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     *headers.Headers
 	Body        []byte
-	state       parserState
+	// BodyReader reads the request body. For a buffered request it reads
+	// Body; for a streaming request it decodes the body from the connection
+	// as it is read, and Body stays nil. Closing it discards whatever is
+	// left of the body.
+	BodyReader io.ReadCloser
+	// Trailers holds the trailer fields sent after a chunked body. It is
+	// empty for requests that are not chunked.
+	Trailers *headers.Headers
+	// TLS holds the negotiated TLS state of the connection the request
+	// arrived on, or nil if it was plaintext.
+	TLS *tls.ConnectionState
+	// PathParams holds the values a router extracted from the request
+	// target, keyed by parameter name.
+	PathParams map[string]string
+	state      parserState
+
+	limits         Limits
+	streaming      bool
+	pending        []byte
+	chunked        bool
+	contentLength  int
+	bodyRead       int
+	fieldBytes     int
+	fieldCount     int
+	chunkRemaining int
 }
 
-func getIntHeader(headers *headers.Headers, name string, defaultValue int) int {
-	value, exists := headers.Get(name)
-	if !exists {
-		return defaultValue
-	}
-	valueInt, err := strconv.Atoi(value)
-	if err != nil {
-		return defaultValue
-	}
-	return valueInt
+// PathParam returns the named path parameter, or "" if it was not set.
+func (r *Request) PathParam(name string) string {
+	return r.PathParams[name]
 }
 
 var SEPARATOR = []byte("\r\n")
 var ErrorMalformedRequestLine = fmt.Errorf("malformed request line")
 var ErrorUnspportedHttpVersion = fmt.Errorf("unsupported HTTP version")
 var ErrorRequestInErrorState = fmt.Errorf("request in error state")
+var ErrorUnsupportedTransferEncoding = fmt.Errorf("unsupported transfer encoding")
 
 const (
 	StateInit    parserState = "init"
@@ -47,12 +65,18 @@ const (
 	StateBody    parserState = "body"
 	StateDone    parserState = "done"
 	StateError   parserState = "error"
+
+	StateChunkSize    parserState = "chunk-size"
+	StateChunkData    parserState = "chunk-data"
+	StateChunkDataEnd parserState = "chunk-data-end"
+	StateTrailers     parserState = "trailers"
 )
 
 func newRequest() *Request {
 	return &Request{
-		state:   StateInit,
-		Headers: headers.NewHeaders(),
+		state:    StateInit,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
 	}
 }
 
@@ -60,6 +84,17 @@ func (r *Request) done() bool {
 	return r.state == StateDone || r.state == StateError
 }
 
+// appendBody stores decoded body bytes, in Body for a buffered request or
+// until they are read for a streaming one.
+func (r *Request) appendBody(data []byte) {
+	r.bodyRead += len(data)
+	if r.streaming {
+		r.pending = append(r.pending, data...)
+		return
+	}
+	r.Body = append(r.Body, data...)
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	readIdx := 0
 outer:
@@ -70,6 +105,10 @@ outer:
 			return 0, ErrorRequestInErrorState
 
 		case StateInit:
+			if err := r.checkRequestLine(currentData); err != nil {
+				r.state = StateError
+				return 0, err
+			}
 			requestLine, n, err := parseRequestLine(currentData)
 			if err != nil {
 				r.state = StateError
@@ -83,7 +122,7 @@ outer:
 			r.state = StateHeaders
 
 		case StateHeaders:
-			n, done, err := r.Headers.Parse(currentData)
+			n, done, err := r.parseFields(r.Headers, currentData)
 			if err != nil {
 				r.state = StateError
 				return 0, err
@@ -94,24 +133,93 @@ outer:
 				break outer
 			}
 			if done {
+				// Framing is fixed by the headers as received, even if
+				// they are changed before the body is read.
+				if err := r.setFraming(); err != nil {
+					r.state = StateError
+					return 0, err
+				}
 				r.state = StateBody
 			}
 
 		case StateBody:
-			contentLength := getIntHeader(r.Headers, "Content-Length", 0)
-			if contentLength == 0 {
+			if r.chunked {
+				r.state = StateChunkSize
+				continue
+			}
+			if r.contentLength == 0 {
 				r.state = StateDone
 				break outer
 			}
-			remaining := min(contentLength-len(r.Body), len(currentData))
-			r.Body = append(r.Body, currentData[:remaining]...)
+			if err := r.checkBodySize(r.contentLength); err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			remaining := min(r.contentLength-r.bodyRead, len(currentData))
+			r.appendBody(currentData[:remaining])
 			readIdx += remaining
 
-			if len(r.Body) == contentLength {
+			if r.bodyRead == r.contentLength {
 				r.state = StateDone
 				break outer
 			}
 
+		case StateChunkSize:
+			size, n, err := parseChunkSize(currentData)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if n == 0 {
+				break outer
+			}
+			readIdx += n
+			if err := r.checkBodySize(r.bodyRead + size); err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if size == 0 {
+				r.state = StateTrailers
+			} else {
+				r.chunkRemaining = size
+				r.state = StateChunkData
+			}
+
+		case StateChunkData:
+			remaining := min(r.chunkRemaining, len(currentData))
+			r.appendBody(currentData[:remaining])
+			r.chunkRemaining -= remaining
+			readIdx += remaining
+			if r.chunkRemaining == 0 {
+				r.state = StateChunkDataEnd
+			}
+
+		case StateChunkDataEnd:
+			if len(currentData) < len(SEPARATOR) {
+				break outer
+			}
+			if !bytes.HasPrefix(currentData, SEPARATOR) {
+				r.state = StateError
+				return 0, ErrorMalformedChunk
+			}
+			readIdx += len(SEPARATOR)
+			r.state = StateChunkSize
+
+		case StateTrailers:
+			n, done, err := r.parseFields(r.Trailers, currentData)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			readIdx += n
+			if done {
+				r.state = StateDone
+				break outer
+			}
+			if n == 0 {
+				break outer
+			}
+
 		case StateDone:
 			break outer
 
@@ -152,44 +260,192 @@ func parseRequestLine(line []byte) (*RequestLine, int, error) {
 	}, readIdx, nil
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
+// Reader parses consecutive requests from a single stream. Bytes read past
+// the end of one request are kept and used for the next, which is what makes
+// persistent connections work.
+type Reader struct {
+	reader io.Reader
+	buf    []byte
+	bufLen int
+	limits Limits
+
+	// DecodeContent hands request bodies sent with a gzip or deflate
+	// Content-Encoding to the handler decoded, with Content-Encoding
+	// removed. Other codings fail with ErrorUnsupportedContentEncoding
+	// before the body is read.
+	DecodeContent bool
+}
+
+// NewReader returns a Reader that enforces DefaultLimits.
+func NewReader(reader io.Reader) *Reader {
+	return NewLimitedReader(reader, DefaultLimits)
+}
+
+func NewLimitedReader(reader io.Reader, limits Limits) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, 1024),
+		limits: limits,
+	}
+}
+
+// ReadRequest parses the next request from the stream. It returns io.EOF if
+// the stream ends cleanly before any byte of a new request arrives.
+func (r *Reader) ReadRequest() (*Request, error) {
+	request, err := r.ReadHeaders()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ReadBody(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// WaitForRequest blocks until the first byte of the next request is
+// available, so callers can tell an idle stream from one in the middle of a
+// request. It returns io.EOF if the stream ends first.
+func (r *Reader) WaitForRequest() error {
+	for r.bufLen == 0 {
+		n, err := r.reader.Read(r.buf)
+		r.bufLen += n
+		if n > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadStreamingRequest parses the request line and headers of the next
+// request and returns without reading the body, which is then read through
+// the request's BodyReader. The BodyReader must be closed before the next
+// request is read from the stream.
+func (r *Reader) ReadStreamingRequest() (*Request, error) {
+	request, err := r.readHeaders(true)
+	if err != nil {
+		return nil, err
+	}
+	request.BodyReader = &body{reader: r, request: request}
+	if !r.DecodeContent {
+		return request, nil
+	}
+	codings, err := request.contentCodings()
+	if err != nil {
+		return nil, err
+	}
+	if len(codings) > 0 {
+		request.BodyReader = &decodingBody{
+			raw:     request.BodyReader,
+			codings: codings,
+			limit:   r.limits.MaxDecodedBodyBytes,
+		}
+		request.markDecoded(-1)
+	}
+	return request, nil
+}
+
+// ReadHeaders parses the request line and headers of the next request. The
+// body is left for ReadBody. Like ReadRequest, it returns io.EOF if the
+// stream ends cleanly before the request starts.
+func (r *Reader) ReadHeaders() (*Request, error) {
+	return r.readHeaders(false)
+}
+
+func (r *Reader) readHeaders(streaming bool) (*Request, error) {
 	request := newRequest()
+	request.limits = r.limits
+	request.streaming = streaming
+	err := r.fill(request, func() bool {
+		return request.state != StateInit && request.state != StateHeaders
+	})
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// ReadBody reads the rest of a request returned by ReadHeaders.
+func (r *Reader) ReadBody(request *Request) error {
+	var codings []string
+	if r.DecodeContent {
+		var err error
+		if codings, err = request.contentCodings(); err != nil {
+			return err
+		}
+	}
+	if err := r.fill(request, request.done); err != nil {
+		return err
+	}
+	if len(codings) > 0 {
+		if err := request.decodeBody(codings); err != nil {
+			return err
+		}
+	}
+	request.BodyReader = io.NopCloser(bytes.NewReader(request.Body))
+	return nil
+}
+
+// fill parses buffered bytes into request, reading more from the stream as
+// needed, until complete reports true.
+func (r *Reader) fill(request *Request, complete func() bool) error {
+	for {
+		if r.bufLen > 0 {
+			readIdx, err := request.parse(r.buf[:r.bufLen])
+			if err != nil {
+				return err
+			}
+			copy(r.buf, r.buf[readIdx:r.bufLen])
+			r.bufLen -= readIdx
+		}
+		if complete() {
+			return nil
+		}
 
-	buf := make([]byte, 1024)
-	bufLen := 0
-	for !request.done() {
-		if bufLen == len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+		if r.bufLen == len(r.buf) {
+			newBuf := make([]byte, len(r.buf)*2)
+			copy(newBuf, r.buf)
+			r.buf = newBuf
 		}
 
-		n, err := reader.Read(buf[bufLen:])
+		n, err := r.reader.Read(r.buf[r.bufLen:])
+		r.bufLen += n
+		if n > 0 {
+			continue
+		}
 		if err != nil {
 			if errors.Is(err, io.EOF) {
+				if request.state == StateInit {
+					if r.bufLen == 0 {
+						return io.EOF
+					}
+					return ErrorMalformedRequestLine
+				}
 				if request.state == StateHeaders {
-					return nil, fmt.Errorf("malformed header")
+					return fmt.Errorf("malformed header")
 				}
-				if request.state == StateBody {
-					contentLength := getIntHeader(request.Headers, "Content-Length", 0)
-					if len(request.Body) < contentLength {
-						return nil, fmt.Errorf("body shorter than Content-Length: got %d, expected %d", len(request.Body), contentLength)
-					}
+				if request.isChunkedState() {
+					return fmt.Errorf("chunked body ended before the last chunk")
+				}
+				if request.state == StateBody && request.bodyRead < request.contentLength {
+					return fmt.Errorf("body shorter than Content-Length: got %d, expected %d", request.bodyRead, request.contentLength)
 				}
 				request.state = StateDone
-				break
+				return nil
 			}
-			return nil, err
-		}
-		bufLen += n
-		readIdx, err := request.parse(buf[:bufLen])
-		if err != nil {
-			return nil, err
+			return err
 		}
-
-		copy(buf, buf[readIdx:bufLen])
-		bufLen -= readIdx
 	}
+}
 
-	return request, nil
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
+}
+
+// StreamingRequestFromReader is like RequestFromReader but returns as soon
+// as the headers are parsed, leaving the body to be read from BodyReader.
+func StreamingRequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadStreamingRequest()
 }
@@ -1,8 +1,17 @@
 package request
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
 	"io"
+	"strconv"
+	"strings"
 	"testing"
+	"webserver/internal/headers"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -200,3 +209,509 @@ func TestRequestBodyParse(t *testing.T) {
 	// Body is ignored since no Content-Length header
 	assert.Equal(t, "", string(r.Body))
 }
+
+func TestRequestChunkedBodyParse(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"5;name=value\r\n" +
+			"hello\r\n" +
+			"7; quoted=\"a b\"\r\n" +
+			" world!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!", string(r.Body))
+	value, exists := r.Trailers.Get("x-checksum")
+	assert.Equal(t, "abc123", value)
+	assert.True(t, exists)
+
+	// Test: Chunked body without trailers, followed by another request
+	stream := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"1A\r\n" +
+			"abcdefghijklmnopqrstuvwxyz\r\n" +
+			"0\r\n" +
+			"\r\n" +
+			"GET /next HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	})
+	r, err = stream.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefghijklmnopqrstuvwxyz", string(r.Body))
+	r, err = stream.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"xyz\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than its declared size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Stream ends before the last chunk
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Transfer coding other than chunked
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: gzip\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrorUnsupportedTransferEncoding)
+}
+
+func TestReaderConsecutiveRequests(t *testing.T) {
+	// Test: Two pipelined requests on the same stream
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	value, exists := r.Headers.Get("host")
+	assert.Equal(t, "localhost:42069", value)
+	assert.True(t, exists)
+
+	// Test: Stream ends cleanly between requests
+	r, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, r)
+
+	// Test: Stream ends in the middle of a request line
+	reader = NewReader(&chunkReader{
+		data:            "GET / HT",
+		numBytesPerRead: 3,
+	})
+	_, err = reader.ReadRequest()
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderCount:      2,
+		MaxHeaderBytes:      64,
+		MaxBodyBytes:        8,
+	}
+	read := func(data string) (*Request, error) {
+		return NewLimitedReader(&chunkReader{
+			data:            data,
+			numBytesPerRead: 5,
+		}, limits).ReadRequest()
+	}
+
+	// Test: Request within every limit
+	r, err := read("POST /ok HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\n\r\n12345678")
+	require.NoError(t, err)
+	assert.Equal(t, "12345678", string(r.Body))
+
+	// Test: Request line too long, even before its CRLF arrives
+	_, err = read("GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\n\r\n")
+	assert.ErrorIs(t, err, ErrorRequestLineTooLong)
+	_, err = read("GET /" + strings.Repeat("a", 40))
+	assert.ErrorIs(t, err, ErrorRequestLineTooLong)
+
+	// Test: Too many header lines
+	_, err = read("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n")
+	assert.ErrorIs(t, err, ErrorHeadersTooLarge)
+
+	// Test: Header block too large, even before it is terminated
+	_, err = read("GET / HTTP/1.1\r\nA: " + strings.Repeat("x", 80) + "\r\n\r\n")
+	assert.ErrorIs(t, err, ErrorHeadersTooLarge)
+	_, err = read("GET / HTTP/1.1\r\nA: " + strings.Repeat("x", 80))
+	assert.ErrorIs(t, err, ErrorHeadersTooLarge)
+
+	// Test: Content-Length over the body limit is rejected before reading the body
+	_, err = read("POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n")
+	assert.ErrorIs(t, err, ErrorBodyTooLarge)
+
+	// Test: Chunked body over the body limit
+	_, err = read("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n")
+	assert.ErrorIs(t, err, ErrorBodyTooLarge)
+
+	// Test: Trailers count towards the header limits
+	_, err = read("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nA: 1\r\nB: 2\r\n\r\n")
+	assert.ErrorIs(t, err, ErrorHeadersTooLarge)
+
+	// Test: Zero limits disable the checks
+	r, err = NewLimitedReader(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 5,
+	}, Limits{}).ReadRequest()
+	require.NoError(t, err)
+	assert.Len(t, r.RequestLine.RequestTarget, 101)
+}
+
+func TestStreamingRequest(t *testing.T) {
+	// Test: Content-Length body is read through BodyReader, then the next request
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world" +
+			"GET /next HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err := reader.ReadStreamingRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+	assert.Nil(t, r.Body)
+	body, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	require.NoError(t, r.BodyReader.Close())
+
+	r, err = reader.ReadStreamingRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// Test: Unread chunked body is drained on Close, keeping trailers
+	reader = NewReader(&chunkReader{
+		data: "POST /chunked HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"6\r\n world\r\n" +
+			"0\r\n" +
+			"X-Sum: 1\r\n" +
+			"\r\n" +
+			"GET /after HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	})
+	r, err = reader.ReadStreamingRequest()
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	n, err := r.BodyReader.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "he", string(buf[:n]))
+	require.NoError(t, r.BodyReader.Close())
+	value, exists := r.Trailers.Get("x-sum")
+	assert.True(t, exists)
+	assert.Equal(t, "1", value)
+	_, err = r.BodyReader.Read(buf)
+	assert.ErrorIs(t, err, ErrorBodyClosed)
+
+	r, err = reader.ReadStreamingRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/after", r.RequestLine.RequestTarget)
+
+	// Test: Body that ends early is reported by Read and Close
+	r, err = StreamingRequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader)
+	require.Error(t, err)
+	assert.Error(t, r.BodyReader.Close())
+
+	// Test: Body limit applies while streaming
+	r, err = NewLimitedReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}, Limits{MaxBodyBytes: 8}).ReadStreamingRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, ErrorBodyTooLarge)
+
+	// Test: Buffered requests can be read through BodyReader too
+	r, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(body))
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// storedGzip wraps data in a gzip member holding uncompressed deflate
+// blocks, which is much cheaper than running a compressor when building
+// thousands of layers.
+func storedGzip(data []byte) []byte {
+	out := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff}
+	for rest, first := data, true; first || len(rest) > 0; first = false {
+		n := min(len(rest), 0xffff)
+		final := byte(0)
+		if n == len(rest) {
+			final = 1
+		}
+		out = append(out, final)
+		out = binary.LittleEndian.AppendUint16(out, uint16(n))
+		out = binary.LittleEndian.AppendUint16(out, ^uint16(n))
+		out = append(out, rest[:n]...)
+		rest = rest[n:]
+	}
+	out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(data))
+	return binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+}
+
+func zlibbed(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestDecodeContent(t *testing.T) {
+	read := func(encoding string, body []byte, limits Limits, stream bool) (*Request, error) {
+		reader := NewLimitedReader(&chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Content-Encoding: " + encoding + "\r\n" +
+				"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+				"\r\n" + string(body),
+			numBytesPerRead: 7,
+		}, limits)
+		reader.DecodeContent = true
+		if stream {
+			return reader.ReadStreamingRequest()
+		}
+		return reader.ReadRequest()
+	}
+	text := []byte(strings.Repeat("upload ", 50))
+
+	// Test: gzip body is decoded and the headers describe the decoded body
+	r, err := read("gzip", gzipped(t, text), DefaultLimits, false)
+	require.NoError(t, err)
+	assert.Equal(t, text, r.Body)
+	_, exists := r.Headers.Get("Content-Encoding")
+	assert.False(t, exists)
+	contentLength, _ := r.Headers.Get("Content-Length")
+	assert.Equal(t, strconv.Itoa(len(text)), contentLength)
+
+	// Test: deflate as zlib or raw data, and stacked codings
+	r, err = read("deflate", zlibbed(t, text), DefaultLimits, false)
+	require.NoError(t, err)
+	assert.Equal(t, text, r.Body)
+	var raw bytes.Buffer
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write(text)
+	fw.Close()
+	r, err = read("deflate", raw.Bytes(), DefaultLimits, false)
+	require.NoError(t, err)
+	assert.Equal(t, text, r.Body)
+	r, err = read("deflate, identity, gzip", gzipped(t, zlibbed(t, text)), DefaultLimits, false)
+	require.NoError(t, err)
+	assert.Equal(t, text, r.Body)
+
+	// Test: Unsupported coding is rejected
+	_, err = read("br", text, DefaultLimits, false)
+	assert.ErrorIs(t, err, ErrorUnsupportedContentEncoding)
+	_, err = read("gzip, compress", text, DefaultLimits, true)
+	assert.ErrorIs(t, err, ErrorUnsupportedContentEncoding)
+
+	// Test: Decoded chunked body is described by Content-Length alone
+	compressed := gzipped(t, text)
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Encoding: gzip\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			strconv.FormatInt(int64(len(compressed)), 16) + "\r\n" + string(compressed) + "\r\n0\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	reader.DecodeContent = true
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, text, r.Body)
+	_, exists = r.Headers.Get("Transfer-Encoding")
+	assert.False(t, exists)
+	contentLength, _ = r.Headers.Get("Content-Length")
+	assert.Equal(t, strconv.Itoa(len(text)), contentLength)
+
+	// Test: Nested codings are capped before any decoding, so thousands of
+	// layers around a tiny body cannot exhaust the server
+	nested := []byte("x")
+	for i := 0; i < 5000; i++ {
+		nested = storedGzip(nested)
+	}
+	encoding := strings.TrimSuffix(strings.Repeat("gzip, ", 5000), ", ")
+	_, err = read(encoding, nested, DefaultLimits, false)
+	assert.ErrorIs(t, err, ErrorUnsupportedContentEncoding)
+	_, err = read(encoding, nested, DefaultLimits, true)
+	assert.ErrorIs(t, err, ErrorUnsupportedContentEncoding)
+	r, err = read("gzip", storedGzip([]byte("x")), DefaultLimits, false)
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(r.Body))
+	_, err = read("gzip, x-gzip", gzipped(t, gzipped(t, text)), DefaultLimits, false)
+	assert.ErrorIs(t, err, ErrorUnsupportedContentEncoding)
+	_, err = read("gzip, deflate, gzip", text, DefaultLimits, false)
+	assert.ErrorIs(t, err, ErrorUnsupportedContentEncoding)
+
+	// Test: Corrupt data is malformed
+	_, err = read("gzip", text, DefaultLimits, false)
+	assert.ErrorIs(t, err, ErrorMalformedContentEncoding)
+	truncated := gzipped(t, text)
+	_, err = read("gzip", truncated[:len(truncated)-10], DefaultLimits, false)
+	assert.ErrorIs(t, err, ErrorMalformedContentEncoding)
+
+	// Test: Decoded size limit stops a zip bomb
+	bomb := gzipped(t, make([]byte, 1<<20))
+	limits := DefaultLimits
+	limits.MaxDecodedBodyBytes = 64 << 10
+	_, err = read("gzip", bomb, limits, false)
+	assert.ErrorIs(t, err, ErrorDecodedBodyTooLarge)
+	limits.MaxDecodedBodyBytes = len(text)
+	_, err = read("gzip", gzipped(t, text), limits, false)
+	assert.NoError(t, err)
+
+	// Test: Streaming body is decoded as it is read
+	r, err = read("gzip", gzipped(t, text), DefaultLimits, true)
+	require.NoError(t, err)
+	_, exists = r.Headers.Get("Content-Length")
+	assert.False(t, exists)
+	body, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, text, body)
+	require.NoError(t, r.BodyReader.Close())
+
+	r, err = read("gzip", bomb, limits, true)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader)
+	assert.ErrorIs(t, err, ErrorDecodedBodyTooLarge)
+
+	// Test: Bodies are left alone unless decoding is enabled
+	compressed = gzipped(t, text)
+	r, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: " + strconv.Itoa(len(compressed)) + "\r\n\r\n" + string(compressed),
+		numBytesPerRead: 7,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, compressed, r.Body)
+}
+
+func TestRequestSmuggling(t *testing.T) {
+	// Each payload is framed differently by at least one well-known proxy or
+	// server, so the only safe answer is to reject it.
+	corpus := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"CL.TE", "POST / HTTP/1.1\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG", ErrorConflictingFraming},
+		{"TE.CL", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n", ErrorConflictingFraming},
+		{"differing Content-Lengths", "POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 7\r\n\r\nhello\r\n", ErrorInvalidContentLength},
+		{"differing Content-Lengths in one field", "POST / HTTP/1.1\r\nContent-Length: 5, 7\r\n\r\nhello\r\n", ErrorInvalidContentLength},
+		{"negative Content-Length", "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n", ErrorInvalidContentLength},
+		{"signed Content-Length", "POST / HTTP/1.1\r\nContent-Length: +5\r\n\r\nhello", ErrorInvalidContentLength},
+		{"hexadecimal Content-Length", "POST / HTTP/1.1\r\nContent-Length: 0x5\r\n\r\nhello", ErrorInvalidContentLength},
+		{"Content-Length with trailing garbage", "POST / HTTP/1.1\r\nContent-Length: 5abc\r\n\r\nhello", ErrorInvalidContentLength},
+		{"empty Content-Length", "POST / HTTP/1.1\r\nContent-Length: \r\n\r\n", ErrorInvalidContentLength},
+		{"overflowing Content-Length", "POST / HTTP/1.1\r\nContent-Length: 99999999999999999999\r\n\r\n", ErrorInvalidContentLength},
+		{"space before colon", "POST / HTTP/1.1\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n", headers.ErrorMalformedHeaderKey},
+		{"tab before colon", "POST / HTTP/1.1\r\nContent-Length\t: 5\r\n\r\nhello", headers.ErrorMalformedHeaderKey},
+		{"folded Transfer-Encoding", "POST / HTTP/1.1\r\nHost: localhost\r\n Transfer-Encoding: chunked\r\n\r\n0\r\n\r\n", headers.ErrorObsoleteLineFolding},
+		{"folded value", "POST / HTTP/1.1\r\nTransfer-Encoding:\r\n\tchunked\r\n\r\n0\r\n\r\n", headers.ErrorObsoleteLineFolding},
+		{"bare LF in field", "POST / HTTP/1.1\r\nX: y\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", headers.ErrorMalformedHeaderValue},
+		{"bare CR in field", "POST / HTTP/1.1\r\nX: y\rContent-Length: 5\r\n\r\nhello", headers.ErrorMalformedHeaderValue},
+		{"NUL in field", "POST / HTTP/1.1\r\nContent-Length: 5\x00\r\n\r\nhello", headers.ErrorMalformedHeaderValue},
+		{"chunked is not final", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n", ErrorUnsupportedTransferEncoding},
+		{"chunked applied twice", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrorUnsupportedTransferEncoding},
+		{"obfuscated chunked", "POST / HTTP/1.1\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n", ErrorUnsupportedTransferEncoding},
+		{"empty Transfer-Encoding", "POST / HTTP/1.1\r\nTransfer-Encoding: \r\nContent-Length: 5\r\n\r\nhello", ErrorConflictingFraming},
+		{"oversized chunk size", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n10000000000000005\r\nhello\r\n0\r\n\r\n", ErrorMalformedChunk},
+		{"chunk size with bare LF", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n", ErrorMalformedChunk},
+		{"chunk data longer than its size", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n", ErrorMalformedChunk},
+	}
+	for _, c := range corpus {
+		_, err := RequestFromReader(&chunkReader{
+			data:            c.data,
+			numBytesPerRead: 3,
+		})
+		assert.ErrorIs(t, err, c.err, c.name)
+	}
+
+	// Test: Repeated Content-Lengths that agree are accepted
+	r, err := RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Transfer-Encoding is matched case-insensitively
+	r, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: Chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+}
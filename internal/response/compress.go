@@ -0,0 +1,169 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"webserver/internal/headers"
+)
+
+// DefaultCompressionMinSize is the body size below which compressing is not
+// worth it.
+const DefaultCompressionMinSize = 1024
+
+// supportedEncodings are the content codings the Writer can apply, in order
+// of preference.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// compressibleTypes are media types, besides text/*, that benefit from
+// compression. Most other types, such as images, video and archives, are
+// compressed already.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/wasm":       true,
+	"application/x-ndjson":   true,
+	"application/xhtml+xml":  true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+// NegotiateEncoding picks the content coding for a response from the
+// Accept-Encoding field value, honoring q-values. It returns "" when the
+// body should be sent as is.
+func NegotiateEncoding(acceptEncoding string) string {
+	accepted := headers.ParseAccept(acceptEncoding)
+	for i := range accepted {
+		if accepted[i].Value == "x-gzip" {
+			accepted[i].Value = "gzip"
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supportedEncodings {
+		q, _ := headers.Quality(accepted, coding)
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Compress makes the Writer compress the body with encoding, "gzip" or
+// "deflate", when the response is eligible: its media type is compressible,
+// it is not already encoded or partial, and it is at least minSize bytes
+// when its size is known. Eligible responses get Vary: Accept-Encoding even
+// when encoding is "", since another client could get a compressed body.
+// It must be called before WriteHeaders.
+func (w *Writer) Compress(encoding string, minSize int) {
+	w.compressEnabled = true
+	w.compressEncoding = encoding
+	w.compressMinSize = minSize
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, err := headers.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// prepareCompression adjusts the header fields of an eligible response and
+// reports whether its body has to be compressed from the first write. A
+// buffered body is decided on once its size is known, in flushBuffered.
+func (w *Writer) prepareCompression(h *headers.Headers) bool {
+	if !w.compressEnabled || bodyless(w.statusCode) || w.statusCode == StatusPartialContent {
+		return false
+	}
+	contentType, _ := h.Get("Content-Type")
+	_, encoded := h.Get("Content-Encoding")
+	_, partial := h.Get("Content-Range")
+	if encoded || partial || !compressibleType(contentType) {
+		return false
+	}
+	if !h.HasToken("Vary", "Accept-Encoding") && !h.HasToken("Vary", "*") {
+		h.Set("Vary", "Accept-Encoding")
+	}
+	if w.compressEncoding == "" {
+		return false
+	}
+
+	_, hasLength := h.Get("Content-Length")
+	_, hasEncoding := h.Get("Transfer-Encoding")
+	switch {
+	case hasLength && !hasEncoding:
+		n, err := h.ContentLength()
+		if err != nil || n < int64(w.compressMinSize) {
+			return false
+		}
+		// The compressed size is not known up front.
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		w.autoChunked = true
+	case !hasLength && !hasEncoding && w.bufferLimit > 0:
+		w.compressPending = true
+		return false
+	}
+	setEncodingHeaders(h, w.compressEncoding)
+	return true
+}
+
+// setEncodingHeaders marks h as describing a body in encoding. A strong
+// ETag is weakened, since the encoded bytes differ from the identity ones.
+func setEncodingHeaders(h *headers.Headers, encoding string) {
+	h.Set("Content-Encoding", encoding)
+	if etag, ok := h.Get("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.Replace("ETag", "W/"+etag)
+	}
+}
+
+func newCompressor(encoding string, dst io.Writer) io.WriteCloser {
+	if encoding == "deflate" {
+		// The deflate content coding is the zlib format.
+		return zlib.NewWriter(dst)
+	}
+	return gzip.NewWriter(dst)
+}
+
+// compressBytes encodes a whole buffered body.
+func compressBytes(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	c := newCompressor(encoding, &buf)
+	if _, err := c.Write(body); err != nil {
+		return nil, err
+	}
+	if err := c.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressedSink receives the compressor's output and writes it with the
+// response's framing.
+type compressedSink struct {
+	w *Writer
+}
+
+func (s compressedSink) Write(p []byte) (int, error) {
+	if s.w.framing == framingChunked {
+		if err := s.w.writeChunk(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return s.w.writer.Write(p)
+}
+
+// closeCompressor flushes the rest of a compressed body.
+func (w *Writer) closeCompressor() error {
+	if w.compressor == nil {
+		return nil
+	}
+	c := w.compressor
+	w.compressor = nil
+	return c.Close()
+}
@@ -0,0 +1,215 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"webserver/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readCompressed parses a written response and returns it with its decoded
+// body.
+func readCompressed(t *testing.T, out *bytes.Buffer) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	var body io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		body, err = gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+	case "deflate":
+		body, err = zlib.NewReader(resp.Body)
+		require.NoError(t, err)
+	}
+	decoded, err := io.ReadAll(body)
+	require.NoError(t, err)
+	return resp, string(decoded)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                              "",
+		"gzip":                          "gzip",
+		"deflate":                       "deflate",
+		"gzip, deflate, br":             "gzip",
+		"deflate;q=1, gzip;q=0.5":       "deflate",
+		"GZIP;Q=0.8":                    "gzip",
+		"x-gzip":                        "gzip",
+		"br, identity":                  "",
+		"*":                             "gzip",
+		"*;q=0.1, gzip;q=0":             "deflate",
+		"gzip;q=0, deflate;q=0":         "",
+		"gzip;q=2, deflate":             "deflate",
+		"gzip;q=abc, deflate;q=0.001":   "deflate",
+		" , gzip ; q=0.5 ,deflate;q=.4": "gzip",
+	}
+	for header, want := range cases {
+		assert.Equal(t, want, NegotiateEncoding(header), header)
+	}
+}
+
+func TestWriterCompress(t *testing.T) {
+	text := strings.Repeat("compress me please ", 100)
+	withType := func(contentType string) *headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", contentType)
+		return h
+	}
+
+	// Test: Buffered body over the threshold gets a compressed Content-Length
+	var out bytes.Buffer
+	w := NewBufferedWriter(&out, 4096)
+	w.Compress("gzip", 100)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(withType("text/plain")))
+	_, err := w.WriteBody([]byte(text))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, len(text), w.BodyBytes())
+	resp, body := readCompressed(t, &out)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Less(t, resp.ContentLength, int64(len(text)))
+	assert.Equal(t, text, body)
+
+	// Test: Buffered body under the threshold is sent as is, with Vary
+	out.Reset()
+	w = NewBufferedWriter(&out, 4096)
+	w.Compress("gzip", 100)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(withType("text/plain")))
+	_, err = w.WriteBody([]byte("short"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	resp, body = readCompressed(t, &out)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, "short", body)
+
+	// Test: Buffered body that outgrows the buffer is compressed in chunks
+	out.Reset()
+	w = NewBufferedWriter(&out, 64)
+	w.Compress("deflate", 10)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(withType("application/json")))
+	for i := 0; i < 10; i++ {
+		_, err = w.WriteBody([]byte(text[:190]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Finish())
+	resp, body = readCompressed(t, &out)
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, strings.Repeat(text[:190], 10), body)
+
+	// Test: Declared Content-Length switches to chunked and weakens a strong ETag
+	out.Reset()
+	w = NewWriter(&out)
+	w.Compress("gzip", 100)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(len(text))
+	h.Set("ETag", `"abc"`)
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte(text))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.CloseAfter())
+	resp, body = readCompressed(t, &out)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, `W/"abc"`, resp.Header.Get("ETag"))
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, text, body)
+
+	// Test: HEAD gets the same headers as GET without a body
+	out.Reset()
+	w = NewWriter(&out)
+	w.OmitBody()
+	w.Compress("gzip", 100)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = GetDefaultHeaders(len(text))
+	h.Set("ETag", `"abc"`)
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte(text))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.CloseAfter())
+	resp, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(out.Bytes())), &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, `W/"abc"`, resp.Header.Get("ETag"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+
+	// Test: Handler-chunked body is compressed chunk by chunk
+	out.Reset()
+	w = NewWriter(&out)
+	w.Compress("gzip", 100)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = withType("text/html")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrorChunkedBody)
+	_, err = w.WriteChunkedBody([]byte(text))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	resp, body = readCompressed(t, &out)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, text, body)
+
+	// Test: Already-compressed types, encoded and partial bodies are left alone
+	for _, h := range []*headers.Headers{withType("video/mp4"), withType("image/png"), withType("application/zip")} {
+		out.Reset()
+		w = NewBufferedWriter(&out, 4096)
+		w.Compress("gzip", 0)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+		_, err = w.WriteBody([]byte(text))
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		resp, body = readCompressed(t, &out)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Empty(t, resp.Header.Get("Vary"))
+		assert.Equal(t, text, body)
+	}
+	out.Reset()
+	w = NewWriter(&out)
+	w.Compress("gzip", 0)
+	require.NoError(t, w.WriteStatusLine(StatusPartialContent))
+	h = GetDefaultHeaders(2)
+	h.Set("Content-Range", "bytes 0-1/10")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte("ab"))
+	require.NoError(t, err)
+	resp, body = readCompressed(t, &out)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "ab", body)
+
+	// Test: Client that accepts no coding still gets Vary
+	out.Reset()
+	w = NewBufferedWriter(&out, 4096)
+	w.Compress("", 0)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = withType("text/plain")
+	h.Set("Vary", "Origin")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte(text))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	resp, body = readCompressed(t, &out)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, text, body)
+	assert.Contains(t, resp.Header.Get("Vary"), "Accept-Encoding")
+	assert.Contains(t, resp.Header.Get("Vary"), "Origin")
+}
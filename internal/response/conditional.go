@@ -0,0 +1,170 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"webserver/internal/headers"
+)
+
+// TimeFormat is the IMF-fixdate format used for HTTP dates.
+const TimeFormat = headers.TimeFormat
+
+// Precondition is the outcome of evaluating a request's conditional headers.
+type Precondition int
+
+const (
+	// PreconditionPassed means the request should be served as usual.
+	PreconditionPassed Precondition = iota
+	// PreconditionNotModified means the client's copy is current and a 304
+	// should be sent.
+	PreconditionNotModified
+	// PreconditionFailed means a 412 should be sent.
+	PreconditionFailed
+)
+
+// Validators identify the current representation of a resource.
+type Validators struct {
+	// ETag is the entity tag as sent on the wire, such as `"abc"` or
+	// `W/"abc"`. Empty means the resource has none.
+	ETag string
+	// LastModified is when the representation last changed. The zero time
+	// means unknown.
+	LastModified time.Time
+}
+
+// SetHeaders adds the ETag and Last-Modified fields for v to h.
+func (v Validators) SetHeaders(h *headers.Headers) {
+	if v.ETag != "" {
+		h.Replace("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.SetTime("Last-Modified", v.LastModified)
+	}
+}
+
+// StrongETag derives a strong entity tag from the full content.
+func StrongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag derives a weak entity tag from a size and modification time,
+// which is cheap for files but may miss changes within the same instant.
+func WeakETag(size int64, modTime time.Time) string {
+	return fmt.Sprintf(`W/"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// EvaluatePreconditions applies If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since from h, in the order given by RFC 9110 section
+// 13.2.2, to a request with the given method for a resource that exists.
+func EvaluatePreconditions(method string, h *headers.Headers, v Validators) Precondition {
+	safe := method == "GET" || method == "HEAD"
+
+	if ifMatch, ok := h.Get("If-Match"); ok {
+		if !matchETag(ifMatch, v.ETag, true) {
+			return PreconditionFailed
+		}
+	} else if since, ok := h.Time("If-Unmodified-Since"); ok && !v.LastModified.IsZero() {
+		if v.LastModified.Truncate(time.Second).After(since) {
+			return PreconditionFailed
+		}
+	}
+
+	if ifNoneMatch, ok := h.Get("If-None-Match"); ok {
+		if matchETag(ifNoneMatch, v.ETag, false) {
+			if safe {
+				return PreconditionNotModified
+			}
+			return PreconditionFailed
+		}
+	} else if since, ok := h.Time("If-Modified-Since"); ok && safe && !v.LastModified.IsZero() {
+		if !v.LastModified.Truncate(time.Second).After(since) {
+			return PreconditionNotModified
+		}
+	}
+	return PreconditionPassed
+}
+
+// IfRangeMatches reports whether a Range request should be honored given
+// its If-Range field: true when there is none, or when it names the current
+// strong ETag or exact Last-Modified date.
+func IfRangeMatches(h *headers.Headers, v Validators) bool {
+	ifRange, ok := h.Get("If-Range")
+	if !ok {
+		return true
+	}
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return matchETag(ifRange, v.ETag, true)
+	}
+	date, err := headers.ParseTime(ifRange)
+	return err == nil && !v.LastModified.IsZero() && v.LastModified.Truncate(time.Second).Equal(date)
+}
+
+// matchETag reports whether the field value, "*" or a list of entity tags,
+// matches current. Strong comparison requires both tags to be strong.
+func matchETag(field, current string, strong bool) bool {
+	if strings.TrimSpace(field) == "*" {
+		return true
+	}
+	if current == "" {
+		return false
+	}
+	currentWeak, currentTag := splitETag(current)
+	if strong && currentWeak {
+		return false
+	}
+	tags, ok := parseETags(field)
+	if !ok {
+		return false
+	}
+	for _, tag := range tags {
+		weak, opaque := splitETag(tag)
+		if strong && weak {
+			continue
+		}
+		if opaque == currentTag {
+			return true
+		}
+	}
+	return false
+}
+
+func splitETag(tag string) (bool, string) {
+	if rest, ok := strings.CutPrefix(tag, "W/"); ok {
+		return true, rest
+	}
+	return false, tag
+}
+
+// parseETags splits a comma-separated list of entity tags. Commas may occur
+// inside the quotes of a tag, so the list is scanned rather than split.
+func parseETags(field string) ([]string, bool) {
+	tags := []string{}
+	for {
+		field = strings.TrimLeft(field, " \t,")
+		if field == "" {
+			return tags, true
+		}
+		start := 0
+		if strings.HasPrefix(field, "W/") {
+			start = 2
+		}
+		if len(field) <= start || field[start] != '"' {
+			return nil, false
+		}
+		end := strings.IndexByte(field[start+1:], '"')
+		if end == -1 {
+			return nil, false
+		}
+		end += start + 2
+		tags = append(tags, field[:end])
+		field = field[end:]
+		if rest := strings.TrimLeft(field, " \t"); rest != "" && rest[0] != ',' {
+			return nil, false
+		}
+	}
+}
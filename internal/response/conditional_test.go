@@ -0,0 +1,114 @@
+package response
+
+import (
+	"testing"
+	"time"
+	"webserver/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluatePreconditions(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	v := Validators{ETag: `"v2"`, LastModified: modified}
+	before := "Wed, 01 May 2024 11:59:59 GMT"
+	at := "Wed, 01 May 2024 12:00:00 GMT"
+	with := func(fields ...string) *headers.Headers {
+		h := headers.NewHeaders()
+		for i := 0; i < len(fields); i += 2 {
+			h.Set(fields[i], fields[i+1])
+		}
+		return h
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		h      *headers.Headers
+		want   Precondition
+	}{
+		{"no conditions", "GET", with(), PreconditionPassed},
+		{"If-None-Match matches", "GET", with("If-None-Match", `"v1", "v2"`), PreconditionNotModified},
+		{"If-None-Match matches weakly", "GET", with("If-None-Match", `W/"v2"`), PreconditionNotModified},
+		{"If-None-Match star", "HEAD", with("If-None-Match", "*"), PreconditionNotModified},
+		{"If-None-Match differs", "GET", with("If-None-Match", `"v1"`), PreconditionPassed},
+		{"If-None-Match on unsafe method", "PUT", with("If-None-Match", `"v2"`), PreconditionFailed},
+		{"If-Match matches", "PUT", with("If-Match", `"v2"`), PreconditionPassed},
+		{"If-Match differs", "PUT", with("If-Match", `"v1"`), PreconditionFailed},
+		{"If-Match is strong", "PUT", with("If-Match", `W/"v2"`), PreconditionFailed},
+		{"If-Match star", "DELETE", with("If-Match", "*"), PreconditionPassed},
+		{"If-Modified-Since current", "GET", with("If-Modified-Since", at), PreconditionNotModified},
+		{"If-Modified-Since older", "GET", with("If-Modified-Since", before), PreconditionPassed},
+		{"If-Modified-Since ignored on POST", "POST", with("If-Modified-Since", at), PreconditionPassed},
+		{"If-Modified-Since invalid", "GET", with("If-Modified-Since", "yesterday"), PreconditionPassed},
+		{"If-Modified-Since obsolete format", "GET", with("If-Modified-Since", "Wednesday, 01-May-24 12:00:00 GMT"), PreconditionNotModified},
+		{"If-Unmodified-Since current", "PUT", with("If-Unmodified-Since", at), PreconditionPassed},
+		{"If-Unmodified-Since older", "PUT", with("If-Unmodified-Since", before), PreconditionFailed},
+		// If-None-Match takes precedence over If-Modified-Since.
+		{"If-None-Match differs, date current", "GET", with("If-None-Match", `"v1"`, "If-Modified-Since", at), PreconditionPassed},
+		// If-Match takes precedence over If-Unmodified-Since.
+		{"If-Match matches, date older", "PUT", with("If-Match", `"v2"`, "If-Unmodified-Since", before), PreconditionPassed},
+		{"If-Match fails before If-None-Match", "GET", with("If-Match", `"v1"`, "If-None-Match", `"v2"`), PreconditionFailed},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, EvaluatePreconditions(c.method, c.h, v), c.name)
+	}
+
+	// Test: Resource without an ETag only matches star
+	assert.Equal(t, PreconditionFailed, EvaluatePreconditions("PUT", with("If-Match", `"v2"`), Validators{}))
+	assert.Equal(t, PreconditionPassed, EvaluatePreconditions("PUT", with("If-Match", "*"), Validators{}))
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	v := Validators{ETag: `"v2"`, LastModified: modified}
+	ifRange := func(value string) *headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("If-Range", value)
+		return h
+	}
+
+	// Test: Missing If-Range always honors the range
+	assert.True(t, IfRangeMatches(headers.NewHeaders(), v))
+
+	// Test: ETag must match strongly
+	assert.True(t, IfRangeMatches(ifRange(`"v2"`), v))
+	assert.False(t, IfRangeMatches(ifRange(`"v1"`), v))
+	assert.False(t, IfRangeMatches(ifRange(`W/"v2"`), v))
+	assert.False(t, IfRangeMatches(ifRange(`"v2"`), Validators{ETag: `W/"v2"`}))
+
+	// Test: Date must match exactly
+	assert.True(t, IfRangeMatches(ifRange("Wed, 01 May 2024 12:00:00 GMT"), v))
+	assert.False(t, IfRangeMatches(ifRange("Wed, 01 May 2024 12:00:01 GMT"), v))
+	assert.False(t, IfRangeMatches(ifRange("not a date"), v))
+}
+
+func TestETags(t *testing.T) {
+	// Test: Strong ETag depends only on content
+	assert.Equal(t, StrongETag([]byte("a")), StrongETag([]byte("a")))
+	assert.NotEqual(t, StrongETag([]byte("a")), StrongETag([]byte("b")))
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, StrongETag([]byte("a")))
+
+	// Test: Weak ETag changes with size and modification time
+	modified := time.Unix(1700000000, 0)
+	assert.Regexp(t, `^W/"[0-9a-f]+-[0-9a-f]+"$`, WeakETag(10, modified))
+	assert.NotEqual(t, WeakETag(10, modified), WeakETag(11, modified))
+	assert.NotEqual(t, WeakETag(10, modified), WeakETag(10, modified.Add(time.Second)))
+
+	// Test: Entity tag lists may contain commas inside tags
+	tags, ok := parseETags(`"a,b", W/"c" ,"d"`)
+	assert.True(t, ok)
+	assert.Equal(t, []string{`"a,b"`, `W/"c"`, `"d"`}, tags)
+	_, ok = parseETags(`"a" b`)
+	assert.False(t, ok)
+	_, ok = parseETags(`unquoted`)
+	assert.False(t, ok)
+
+	// Test: Validators are written as headers
+	h := headers.NewHeaders()
+	Validators{ETag: `"x"`, LastModified: modified}.SetHeaders(h)
+	etag, _ := h.Get("ETag")
+	lastModified, _ := h.Get("Last-Modified")
+	assert.Equal(t, `"x"`, etag)
+	assert.Equal(t, "Tue, 14 Nov 2023 22:13:20 GMT", lastModified)
+}
@@ -0,0 +1,84 @@
+package response
+
+import (
+	"fmt"
+	"strings"
+	"webserver/internal/headers"
+)
+
+// HeaderPolicy decides what WriteHeaders and WriteTrailers do with a field
+// that cannot be written as a single line, such as a value reflected from
+// the request that carries a CRLF.
+type HeaderPolicy int
+
+const (
+	// HeaderPolicyReject fails the write with a *HeaderFieldError and sends
+	// nothing.
+	HeaderPolicyReject HeaderPolicy = iota
+	// HeaderPolicySanitize drops fields whose name is not a token and
+	// replaces CR, LF and NUL in values with spaces.
+	HeaderPolicySanitize
+)
+
+var ErrorInvalidHeaderName = fmt.Errorf("header name is not a token")
+var ErrorInvalidHeaderValue = fmt.Errorf("header value contains CR, LF or NUL")
+
+// HeaderFieldError is returned under HeaderPolicyReject for a field that
+// could inject extra lines into the response. It matches
+// ErrorInvalidHeaderName or ErrorInvalidHeaderValue with errors.Is.
+type HeaderFieldError struct {
+	Name string
+	Err  error
+}
+
+func (e *HeaderFieldError) Error() string {
+	return fmt.Sprintf("response: header %q: %v", e.Name, e.Err)
+}
+
+func (e *HeaderFieldError) Unwrap() error {
+	return e.Err
+}
+
+// SetHeaderPolicy changes how invalid header and trailer fields are handled.
+// The default is HeaderPolicyReject.
+func (w *Writer) SetHeaderPolicy(policy HeaderPolicy) {
+	w.headerPolicy = policy
+}
+
+// checkFields applies the header policy to h and returns the fields that
+// are safe to write.
+func (w *Writer) checkFields(h *headers.Headers) (*headers.Headers, error) {
+	checked := headers.NewHeaders()
+	var err error
+	h.ForEach(func(name, value string) {
+		if err != nil {
+			return
+		}
+		switch {
+		case !headers.IsToken([]byte(name)):
+			if w.headerPolicy != HeaderPolicySanitize {
+				err = &HeaderFieldError{Name: name, Err: ErrorInvalidHeaderName}
+			}
+			return
+		case strings.ContainsAny(value, "\r\n\x00"):
+			if w.headerPolicy != HeaderPolicySanitize {
+				err = &HeaderFieldError{Name: name, Err: ErrorInvalidHeaderValue}
+				return
+			}
+			value = sanitizeValue(value)
+		}
+		checked.Add(name, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checked, nil
+}
+
+// valueSanitizer replaces the bytes that could end a field line. It works on
+// bytes rather than runes, so obs-text in a value is sent unchanged.
+var valueSanitizer = strings.NewReplacer("\r", " ", "\n", " ", "\x00", " ")
+
+func sanitizeValue(value string) string {
+	return valueSanitizer.Replace(value)
+}
@@ -0,0 +1,179 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"webserver/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fieldLines returns the lines of a header or trailer block, failing if any
+// of them carries a stray CR or LF.
+func fieldLines(t *testing.T, block string) []string {
+	t.Helper()
+	require.True(t, strings.HasSuffix(block, "\r\n"), "block not terminated: %q", block)
+	lines := strings.Split(strings.TrimSuffix(block, "\r\n"), "\r\n")
+	require.Equal(t, "", lines[len(lines)-1], "block not terminated: %q", block)
+	lines = lines[:len(lines)-1]
+	for _, line := range lines {
+		require.NotContains(t, line, "\r")
+		require.NotContains(t, line, "\n")
+	}
+	return lines
+}
+
+func TestHeaderPolicy(t *testing.T) {
+	injected := func(name, value string) *headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+		h.Set(name, value)
+		return h
+	}
+
+	// Test: CRLF in a value is rejected and nothing is written
+	var out bytes.Buffer
+	w := NewBufferedWriter(&out, DefaultBufferLimit)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	err := w.WriteHeaders(injected("Location", "/next\r\nSet-Cookie: session=evil"))
+	var fieldErr *HeaderFieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "Location", fieldErr.Name)
+	assert.ErrorIs(t, err, ErrorInvalidHeaderValue)
+	assert.Empty(t, out.String())
+
+	// Test: The rejected response can be replaced with another
+	require.True(t, w.Reset())
+	require.NoError(t, w.WriteStatusLine(StatusInternalServerError))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 500 "))
+
+	// Test: Names that are not tokens are rejected
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.ErrorIs(t, w.WriteHeaders(injected("X-Evil\r\nSet-Cookie", "1")), ErrorInvalidHeaderName)
+	assert.ErrorIs(t, w.WriteHeaders(injected("X Evil", "1")), ErrorInvalidHeaderName)
+	assert.ErrorIs(t, w.WriteHeaders(injected("X-Nul", "a\x00b")), ErrorInvalidHeaderValue)
+
+	// Test: Fields added through Headers are checked too
+	out.Reset()
+	w = NewWriter(&out)
+	w.Headers().Set("X-Request-Id", "abc\n")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(0)), ErrorInvalidHeaderValue)
+
+	// Test: Sanitizing replaces line breaks and drops invalid names
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetHeaderPolicy(HeaderPolicySanitize)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := injected("Location", "/next\r\nSet-Cookie: session=evil")
+	h.Set("X-Evil\r\nSet-Cookie", "1")
+	h.Set("Content-Length", "0")
+	require.NoError(t, w.WriteHeaders(h))
+	block := strings.TrimPrefix(out.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Equal(t, []string{
+		"Content-Type: text/plain",
+		"Location: /next  Set-Cookie: session=evil",
+		"Content-Length: 0",
+	}, fieldLines(t, block))
+
+	// Test: Sanitizing leaves obs-text bytes alone
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetHeaderPolicy(HeaderPolicySanitize)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = headers.NewHeaders()
+	h.Set("X-Name", "caf\xe9\r\n\x00\xff")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Contains(t, out.String(), "X-Name: caf\xe9   \xff\r\n")
+
+	// Test: Trailers follow the same policy
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc\r\n\r\nHTTP/1.1 200 OK")
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrorInvalidHeaderValue)
+	w.SetHeaderPolicy(HeaderPolicySanitize)
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.True(t, strings.HasSuffix(out.String(), "0\r\nX-Checksum: abc    HTTP/1.1 200 OK\r\n\r\n"))
+}
+
+// FuzzWriteHeaders checks that no name or value can add a line to the header
+// block, whichever the policy.
+func FuzzWriteHeaders(f *testing.F) {
+	f.Add("X-Test", "value")
+	f.Add("Location", "/\r\nSet-Cookie: a=b")
+	f.Add("X-Test", "a\nb\rc\x00d")
+	f.Add("X-Test\r\nX-Evil", "1")
+	f.Add("", "")
+	f.Add("Set-Cookie", "a=1\r\n\r\n<html>")
+	f.Fuzz(func(t *testing.T, name, value string) {
+		for _, policy := range []HeaderPolicy{HeaderPolicyReject, HeaderPolicySanitize} {
+			var out bytes.Buffer
+			w := NewWriter(&out)
+			w.SetHeaderPolicy(policy)
+			require.NoError(t, w.WriteStatusLine(StatusOK))
+			h := headers.NewHeaders()
+			h.Set(name, value)
+			err := w.WriteHeaders(h)
+			var fieldErr *HeaderFieldError
+			if errors.As(err, &fieldErr) {
+				assert.Equal(t, HeaderPolicyReject, policy)
+				assert.Equal(t, "HTTP/1.1 200 OK\r\n", out.String())
+				continue
+			}
+			if err != nil {
+				// Framing errors, such as a bad Content-Length, write
+				// nothing either.
+				assert.Equal(t, "HTTP/1.1 200 OK\r\n", out.String())
+				continue
+			}
+			lines := fieldLines(t, strings.TrimPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+			assert.LessOrEqual(t, len(lines), 1)
+		}
+	})
+}
+
+// FuzzWriteTrailers is FuzzWriteHeaders for trailer fields.
+func FuzzWriteTrailers(f *testing.F) {
+	f.Add("X-Checksum", "abc")
+	f.Add("X-Checksum", "abc\r\n\r\nHTTP/1.1 200 OK")
+	f.Add("X\nY", "1")
+	f.Fuzz(func(t *testing.T, name, value string) {
+		for _, policy := range []HeaderPolicy{HeaderPolicyReject, HeaderPolicySanitize} {
+			var out bytes.Buffer
+			w := NewWriter(&out)
+			w.SetHeaderPolicy(policy)
+			require.NoError(t, w.WriteStatusLine(StatusOK))
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			require.NoError(t, w.WriteHeaders(h))
+			_, err := w.WriteChunkedBodyDone()
+			require.NoError(t, err)
+			prefix := out.String()
+
+			trailers := headers.NewHeaders()
+			trailers.Set(name, value)
+			err = w.WriteTrailers(trailers)
+			if err != nil {
+				assert.Equal(t, HeaderPolicyReject, policy)
+				assert.ErrorAs(t, err, new(*HeaderFieldError))
+				assert.Equal(t, prefix, out.String())
+				continue
+			}
+			lines := fieldLines(t, strings.TrimPrefix(out.String(), prefix))
+			assert.LessOrEqual(t, len(lines), 1)
+		}
+	})
+}
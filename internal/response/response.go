@@ -13,77 +13,478 @@ type response struct {
 	body       []byte
 }
 
-type Writer struct {
-	writer io.Writer
+// writerState tracks which part of the response the Writer expects next.
+type writerState int
+
+const (
+	writerStateStatusLine writerState = iota
+	writerStateHeaders
+	writerStateBody
+	writerStateTrailers
+	writerStateDone
+)
+
+func (s writerState) String() string {
+	switch s {
+	case writerStateStatusLine:
+		return "status line"
+	case writerStateHeaders:
+		return "headers"
+	case writerStateBody:
+		return "body"
+	case writerStateTrailers:
+		return "trailers"
+	case writerStateDone:
+		return "done"
+	}
+	return fmt.Sprintf("writerState(%d)", int(s))
 }
 
-type StatusCode int
+// bodyFraming is how the client finds the end of the body, as decided by the
+// headers.
+type bodyFraming int
 
 const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	framingNone bodyFraming = iota
+	framingContentLength
+	framingChunked
+	framingClose
+	// framingBuffered holds the body back until the handler finishes or it
+	// outgrows the buffer, and then settles on Content-Length or chunked.
+	framingBuffered
 )
 
+// DefaultBufferLimit is the body size up to which a buffered Writer sends a
+// Content-Length instead of switching to chunked encoding.
+const DefaultBufferLimit = 4096
+
+// WriteOrderError is returned when a Writer method is called while the
+// response is in a state that does not allow it, such as writing the body
+// before the headers.
+type WriteOrderError struct {
+	Op    string
+	State string
+}
+
+func (e *WriteOrderError) Error() string {
+	return fmt.Sprintf("response: %s called while expecting %s", e.Op, e.State)
+}
+
+var ErrorConflictingFraming = fmt.Errorf("response has both Content-Length and Transfer-Encoding")
+var ErrorInvalidContentLength = headers.ErrorInvalidContentLength
+var ErrorBodyExceedsContentLength = fmt.Errorf("body exceeds Content-Length")
+var ErrorBodyNotAllowed = fmt.Errorf("response status does not allow a body")
+var ErrorChunkedBody = fmt.Errorf("body is chunked; use WriteChunkedBody")
+var ErrorNotChunkedBody = fmt.Errorf("body is not chunked; use WriteBody")
+
+type Writer struct {
+	writer     io.Writer
+	closeAfter bool
+
+	headers    *headers.Headers
+	state      writerState
+	framing    bodyFraming
+	statusCode StatusCode
+	// contentLength is the declared body size when framing is
+	// framingContentLength.
+	contentLength int
+	bodyBytes     int
+	aborted       bool
+	headerPolicy  HeaderPolicy
+	// omitBody discards the body of a response to a HEAD request.
+	omitBody bool
+
+	// bufferLimit enables buffered mode when positive. In that mode the
+	// status line and headers are held back until the framing is known.
+	bufferLimit    int
+	statusReason   string
+	pendingHeaders *headers.Headers
+	buf            []byte
+	// autoChunked is set once a buffered body outgrew the buffer, or a
+	// compressed body lost its Content-Length, after which WriteBody sends
+	// chunks.
+	autoChunked bool
+
+	// Compression is configured by Compress. compressPending defers the
+	// decision for a buffered body until its size is known; compressor is
+	// set while the body is being compressed.
+	compressEnabled  bool
+	compressEncoding string
+	compressMinSize  int
+	compressPending  bool
+	compressor       io.WriteCloser
+}
+
 func NewWriter(writer io.Writer) *Writer {
 	return &Writer{
-		writer: writer,
+		writer:  writer,
+		headers: headers.NewHeaders(),
 	}
 }
 
+// NewBufferedWriter returns a Writer in buffered mode. When the handler sends
+// headers with neither Content-Length nor Transfer-Encoding, body writes are
+// collected up to limit bytes. If the handler finishes within the limit the
+// response goes out with a Content-Length; otherwise the writer switches to
+// chunked encoding and streams the rest.
+func NewBufferedWriter(writer io.Writer, limit int) *Writer {
+	w := NewWriter(writer)
+	w.bufferLimit = limit
+	return w
+}
+
 func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
-	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 	h.Set("Content-Length", strconv.Itoa(contentLen))
 	return h
 }
 
+// expect returns a WriteOrderError for op unless the writer is in state.
+func (w *Writer) expect(op string, state writerState) error {
+	if w.state != state {
+		return &WriteOrderError{Op: op, State: w.state.String()}
+	}
+	return nil
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	statusLine := []byte{}
-	switch statusCode {
-	case StatusOK:
-		statusLine = []byte("HTTP/1.1 200 OK\r\n")
-	case StatusBadRequest:
-		statusLine = []byte("HTTP/1.1 400 Bad Request\r\n")
-	case StatusInternalServerError:
-		statusLine = []byte("HTTP/1.1 500 Internal Server Error\r\n")
-	}
-	_, err := w.writer.Write(statusLine)
+	return w.WriteStatusLineReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineReason writes a status line with a custom reason phrase.
+// Codes outside 100-999 and reasons containing control characters are
+// rejected without writing anything.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
+	if err := w.expect("WriteStatusLine", writerStateStatusLine); err != nil {
+		return err
+	}
+	if !validStatusCode(statusCode) {
+		return ErrorInvalidStatusCode
+	}
+	if !validReasonPhrase(reason) {
+		return ErrorInvalidReasonPhrase
+	}
+	w.statusCode = statusCode
+	w.statusReason = reason
+	w.state = writerStateHeaders
+	if w.bufferLimit > 0 {
+		return nil
+	}
+	return w.writeStatusLine()
+}
+
+func (w *Writer) writeStatusLine() error {
+	_, err := fmt.Fprintf(w.writer, "HTTP/1.1 %d %s\r\n", w.statusCode, w.statusReason)
 	return err
 }
 
+// Headers returns fields that are added to the next WriteHeaders call unless
+// the handler sets them itself. Middleware uses it to contribute headers to
+// responses it does not write.
+func (w *Writer) Headers() *headers.Headers {
+	return w.headers
+}
+
+// StatusCode returns the status code written so far, or 0 if the status line
+// has not been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// HeadersWritten reports whether the header block has been sent, after which
+// the status code can no longer change.
+func (w *Writer) HeadersWritten() bool {
+	return w.state > writerStateHeaders && w.pendingHeaders == nil
+}
+
+// BodyBytes returns the number of body bytes written, excluding chunk
+// framing.
+func (w *Writer) BodyBytes() int {
+	return w.bodyBytes
+}
+
+// Reset discards the status line, headers and body written so far, as long
+// as none of it has reached the connection yet, and reports whether it
+// could. Fields added through Headers are kept.
+func (w *Writer) Reset() bool {
+	if w.aborted {
+		return false
+	}
+	switch {
+	case w.state == writerStateStatusLine:
+	case w.bufferLimit > 0 && w.state == writerStateHeaders:
+	case w.state == writerStateBody && w.pendingHeaders != nil:
+	default:
+		return false
+	}
+	w.state = writerStateStatusLine
+	w.framing = framingNone
+	w.statusCode = 0
+	w.statusReason = ""
+	w.pendingHeaders = nil
+	w.buf = nil
+	w.bodyBytes = 0
+	w.closeAfter = false
+	w.autoChunked = false
+	w.compressPending = false
+	w.compressor = nil
+	return true
+}
+
+// OmitBody makes the Writer send the status line and headers a GET would
+// get, Content-Length and compression fields included, and discard the body,
+// as a response to a HEAD request must. Body writes still succeed, so handlers need not tell HEAD
+// from GET. It must be called before WriteHeaders.
+func (w *Writer) OmitBody() {
+	w.omitBody = true
+}
+
+// Abort marks the response as unusable, so the connection is closed once the
+// handler returns instead of carrying another request.
+func (w *Writer) Abort() {
+	w.aborted = true
+	w.closeAfter = true
+}
+
+// CloseAfter reports whether the connection has to be closed once the
+// response is written, either because the headers asked for it or because
+// the response has no framing the client could use to find its end.
+func (w *Writer) CloseAfter() bool {
+	return w.closeAfter
+}
+
+// bodyless reports whether the status code forbids a response body.
+func bodyless(statusCode StatusCode) bool {
+	return statusCode < 200 || statusCode == StatusNoContent || statusCode == StatusNotModified
+}
+
 func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	if err := w.expect("WriteHeaders", writerStateHeaders); err != nil {
+		return err
+	}
+
+	// The handler's fields go out first and in its order, followed by those
+	// added through Headers that it did not set itself.
+	merged := headers.NewHeaders()
+	h.ForEach(merged.Add)
+	w.headers.ForEach(func(name, value string) {
+		if _, exists := h.Get(name); !exists {
+			merged.Add(name, value)
+		}
+	})
+	merged, err := w.checkFields(merged)
+	if err != nil {
+		return err
+	}
+
+	_, hasLength := merged.Get("Content-Length")
+	_, hasEncoding := merged.Get("Transfer-Encoding")
+	if hasLength && hasEncoding {
+		return ErrorConflictingFraming
+	}
+	// HEAD gets the same fields as GET, so the headers are prepared for
+	// compression even when no body will be compressed.
+	compress := w.prepareCompression(merged) && !w.omitBody
+	_, hasLength = merged.Get("Content-Length")
+	_, hasEncoding = merged.Get("Transfer-Encoding")
+	switch {
+	case bodyless(w.statusCode) || w.omitBody:
+		w.framing = framingNone
+	case hasEncoding:
+		if !merged.HasToken("Transfer-Encoding", "chunked") {
+			return ErrorNotChunkedBody
+		}
+		w.framing = framingChunked
+	case hasLength:
+		n, err := merged.ContentLength()
+		if err != nil {
+			return err
+		}
+		w.framing = framingContentLength
+		w.contentLength = int(n)
+	case w.bufferLimit > 0:
+		w.framing = framingBuffered
+	default:
+		w.framing = framingClose
+		w.closeAfter = true
+	}
+	if merged.HasToken("Connection", "close") {
+		w.closeAfter = true
+	}
+
+	w.state = writerStateBody
+	if w.framing == framingBuffered {
+		w.pendingHeaders = merged
+		return nil
+	}
+	if compress {
+		w.compressor = newCompressor(w.compressEncoding, compressedSink{w})
+	}
+	return w.writeHeaderBlock(merged)
+}
+
+// writeHeaderBlock sends the header fields, preceded by the status line when
+// buffered mode held it back.
+func (w *Writer) writeHeaderBlock(h *headers.Headers) error {
 	b := []byte{}
+	if w.bufferLimit > 0 {
+		b = fmt.Appendf(b, "HTTP/1.1 %d %s\r\n", w.statusCode, w.statusReason)
+	}
 	h.ForEach(func(name, value string) {
 		b = fmt.Appendf(b, "%s: %s\r\n", name, value)
 	})
 	b = append(b, headers.SEPARATOR...)
+	w.pendingHeaders = nil
 	_, err := w.writer.Write(b)
 	return err
 }
 
+// flushBuffered sends a buffered response. When final is set the whole body
+// is known and goes out with a Content-Length; otherwise the response
+// switches to chunked encoding and the buffer becomes its first chunk.
+func (w *Writer) flushBuffered(final bool) error {
+	h := w.pendingHeaders
+	body := w.buf
+	w.buf = nil
+	compress := w.compressPending && (!final || len(body) >= w.compressMinSize)
+	w.compressPending = false
+	if compress {
+		setEncodingHeaders(h, w.compressEncoding)
+	}
+	if final {
+		if compress {
+			var err error
+			if body, err = compressBytes(w.compressEncoding, body); err != nil {
+				return err
+			}
+		}
+		h.Replace("Content-Length", strconv.Itoa(len(body)))
+		w.framing = framingContentLength
+		w.contentLength = len(body)
+		if err := w.writeHeaderBlock(h); err != nil {
+			return err
+		}
+		_, err := w.writer.Write(body)
+		return err
+	}
+
+	h.Replace("Transfer-Encoding", "chunked")
+	w.framing = framingChunked
+	w.autoChunked = true
+	if err := w.writeHeaderBlock(h); err != nil {
+		return err
+	}
+	if compress {
+		w.compressor = newCompressor(w.compressEncoding, compressedSink{w})
+		_, err := w.compressor.Write(body)
+		return err
+	}
+	return w.writeChunk(body)
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
+	if err := w.expect("WriteBody", writerStateBody); err != nil {
+		return 0, err
+	}
+	if w.omitBody {
+		w.bodyBytes += len(p)
+		return len(p), nil
+	}
+	if w.compressor != nil && (w.framing != framingChunked || w.autoChunked) {
+		n, err := w.compressor.Write(p)
+		w.bodyBytes += n
+		return n, err
+	}
+	switch w.framing {
+	case framingNone:
+		if len(p) > 0 {
+			return 0, ErrorBodyNotAllowed
+		}
+	case framingBuffered:
+		w.buf = append(w.buf, p...)
+		w.bodyBytes += len(p)
+		if len(w.buf) > w.bufferLimit {
+			if err := w.flushBuffered(false); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case framingChunked:
+		if w.autoChunked {
+			if err := w.writeChunk(p); err != nil {
+				return 0, err
+			}
+			w.bodyBytes += len(p)
+			return len(p), nil
+		}
+		return 0, ErrorChunkedBody
+	case framingContentLength:
+		if w.bodyBytes+len(p) > w.contentLength {
+			return 0, ErrorBodyExceedsContentLength
+		}
+	}
 	n, err := w.writer.Write(p)
+	w.bodyBytes += n
 	return n, err
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	n := len(p)
-	w.WriteBody([]byte(fmt.Sprintf("%x\r\n", n)))
-	_, err := w.WriteBody(p[:n])
-	if err != nil {
+	if err := w.expect("WriteChunkedBody", writerStateBody); err != nil {
 		return 0, err
 	}
-	_, err = w.WriteBody([]byte("\r\n"))
-	if err != nil {
+	if w.omitBody {
+		w.bodyBytes += len(p)
+		return len(p), nil
+	}
+	if w.framing != framingChunked {
+		return 0, ErrorNotChunkedBody
+	}
+	if w.compressor != nil {
+		n, err := w.compressor.Write(p)
+		w.bodyBytes += n
+		return n, err
+	}
+	if err := w.writeChunk(p); err != nil {
 		return 0, err
 	}
-	return n, nil
+	w.bodyBytes += len(p)
+	return len(p), nil
+}
+
+func (w *Writer) writeChunk(p []byte) error {
+	// An empty chunk would be read as the last one.
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := w.writer.Write([]byte(fmt.Sprintf("%x\r\n", len(p))))
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(p)
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write([]byte("\r\n"))
+	return err
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	_, err := w.WriteBody([]byte("0\r\n"))
+	if err := w.expect("WriteChunkedBodyDone", writerStateBody); err != nil {
+		return 0, err
+	}
+	if w.omitBody {
+		w.state = writerStateTrailers
+		return 0, nil
+	}
+	if w.framing != framingChunked {
+		return 0, ErrorNotChunkedBody
+	}
+	if err := w.closeCompressor(); err != nil {
+		return 0, err
+	}
+	w.state = writerStateTrailers
+	_, err := w.writer.Write([]byte("0\r\n"))
 	if err != nil {
 		return 0, err
 	}
@@ -91,14 +492,72 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 }
 
 func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if err := w.expect("WriteTrailers", writerStateTrailers); err != nil {
+		return err
+	}
+	h, err := w.checkFields(h)
+	if err != nil {
+		return err
+	}
+	if w.omitBody {
+		w.state = writerStateDone
+		return nil
+	}
 	b := []byte{}
 	h.ForEach(func(name, value string) {
 		b = fmt.Appendf(b, "%s: %s\r\n", name, value)
 	})
 	b = append(b, headers.SEPARATOR...)
-	_, err := w.writer.Write(b)
+	w.state = writerStateDone
+	_, err = w.writer.Write(b)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// Finish completes whatever the handler left unwritten. A handler that wrote
+// nothing gets an empty 200, missing headers get the defaults, and a chunked
+// body is terminated. A Content-Length body that came up short cannot be
+// repaired, so the response is aborted instead.
+func (w *Writer) Finish() error {
+	if w.aborted {
+		return nil
+	}
+	switch w.state {
+	case writerStateStatusLine:
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+		fallthrough
+	case writerStateHeaders:
+		if err := w.WriteHeaders(GetDefaultHeaders(0)); err != nil {
+			return err
+		}
+		fallthrough
+	case writerStateBody:
+		switch w.framing {
+		case framingBuffered:
+			if err := w.flushBuffered(true); err != nil {
+				return err
+			}
+		case framingChunked:
+			if _, err := w.WriteChunkedBodyDone(); err != nil {
+				return err
+			}
+			return w.WriteTrailers(headers.NewHeaders())
+		case framingContentLength:
+			if w.bodyBytes < w.contentLength {
+				w.Abort()
+			}
+		case framingClose:
+			if err := w.closeCompressor(); err != nil {
+				return err
+			}
+		}
+		w.state = writerStateDone
+	case writerStateTrailers:
+		return w.WriteTrailers(headers.NewHeaders())
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"webserver/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	// Test: Registered codes use their standard reason phrase
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", out.String())
+	assert.Equal(t, StatusNotFound, w.StatusCode())
+
+	out.Reset()
+	require.NoError(t, NewWriter(&out).WriteStatusLine(StatusTooManyRequests))
+	assert.Equal(t, "HTTP/1.1 429 Too Many Requests\r\n", out.String())
+
+	// Test: Unregistered code in range keeps an empty reason
+	out.Reset()
+	require.NoError(t, NewWriter(&out).WriteStatusLine(599))
+	assert.Equal(t, "HTTP/1.1 599 \r\n", out.String())
+
+	// Test: Custom reason phrase
+	out.Reset()
+	require.NoError(t, NewWriter(&out).WriteStatusLineReason(StatusOK, "Totally Fine"))
+	assert.Equal(t, "HTTP/1.1 200 Totally Fine\r\n", out.String())
+
+	// Test: Codes outside 100-999 are rejected
+	out.Reset()
+	assert.ErrorIs(t, NewWriter(&out).WriteStatusLine(99), ErrorInvalidStatusCode)
+	assert.ErrorIs(t, NewWriter(&out).WriteStatusLine(1000), ErrorInvalidStatusCode)
+	assert.Empty(t, out.String())
+
+	// Test: Reason phrases cannot contain line breaks
+	assert.ErrorIs(t, NewWriter(&out).WriteStatusLineReason(StatusOK, "OK\r\nX-Evil: 1"), ErrorInvalidReasonPhrase)
+	assert.Empty(t, out.String())
+}
+
+func TestWriteHeaders(t *testing.T) {
+	// Test: Fields are written in order with their casing, one line each
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.Headers().Set("X-Request-Id", "abc")
+	w.Headers().Set("Content-Type", "text/html")
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	h.Set("Set-Cookie", "a=1; Path=/")
+	h.Set("Set-Cookie", "b=2")
+	h.Set("Content-Length", "0")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Set-Cookie: a=1; Path=/\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"Content-Length: 0\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"\r\n", out.String())
+}
+
+func TestWriterOrder(t *testing.T) {
+	// Test: Body before headers is rejected
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	_, err := w.WriteBody([]byte("hello"))
+	var orderErr *WriteOrderError
+	require.ErrorAs(t, err, &orderErr)
+	assert.Equal(t, "WriteBody", orderErr.Op)
+	assert.Equal(t, "status line", orderErr.State)
+	assert.Empty(t, out.String())
+
+	// Test: Status line cannot be written twice
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.ErrorAs(t, w.WriteStatusLine(StatusOK), &orderErr)
+
+	// Test: Content-Length and Transfer-Encoding together are rejected
+	h := GetDefaultHeaders(5)
+	h.Set("Transfer-Encoding", "chunked")
+	assert.ErrorIs(t, w.WriteHeaders(h), ErrorConflictingFraming)
+
+	// Test: Chunked writes are rejected on a Content-Length body
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	assert.ErrorIs(t, err, ErrorNotChunkedBody)
+
+	// Test: Body cannot exceed Content-Length
+	_, err = w.WriteBody([]byte("hello!"))
+	assert.ErrorIs(t, err, ErrorBodyExceedsContentLength)
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.CloseAfter())
+
+	// Test: Plain writes are rejected on a chunked body, trailers only after the last chunk
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte("hello"))
+	assert.ErrorIs(t, err, ErrorChunkedBody)
+	require.ErrorAs(t, w.WriteTrailers(GetDefaultHeaders(0)), &orderErr)
+
+	// Test: Body is not allowed on 204
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err = w.WriteBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrorBodyNotAllowed)
+}
+
+func TestWriterFinish(t *testing.T) {
+	// Test: Nothing written sends an empty 200
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, out.String(), "Content-Length: 0\r\n")
+	assert.True(t, w.HeadersWritten())
+
+	// Test: Status only gets default headers
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "HTTP/1.1 404 Not Found\r\n")
+	assert.Contains(t, out.String(), "Content-Length: 0\r\n")
+
+	// Test: Unfinished chunked body is terminated
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(out.Bytes(), []byte("2\r\nhi\r\n0\r\n\r\n")))
+	assert.False(t, w.CloseAfter())
+
+	// Test: Short Content-Length body aborts the connection
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err = w.WriteBody([]byte("short"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.CloseAfter())
+}
+
+func TestWriterOmitBody(t *testing.T) {
+	// Test: Content-Length is sent but the body is discarded
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.OmitBody()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Content-Length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+	assert.Equal(t, 5, w.BodyBytes())
+	assert.False(t, w.CloseAfter())
+
+	// Test: Chunked bodies and trailers are discarded too
+	out.Reset()
+	w = NewWriter(&out)
+	w.OmitBody()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+	assert.NotContains(t, out.String(), "hi")
+	assert.False(t, w.CloseAfter())
+}
+
+func TestBufferedWriter(t *testing.T) {
+	textHeaders := func() *headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+		return h
+	}
+
+	// Test: Small body gets a Content-Length
+	var out bytes.Buffer
+	w := NewBufferedWriter(&out, 16)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(textHeaders()))
+	_, err := w.WriteBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("world"))
+	require.NoError(t, err)
+	assert.Empty(t, out.String())
+	assert.False(t, w.HeadersWritten())
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out.String(), "Content-Length: 11\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nhello world"))
+	assert.False(t, w.CloseAfter())
+
+	// Test: Body over the limit switches to chunked encoding
+	out.Reset()
+	w = NewBufferedWriter(&out, 4)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(textHeaders()))
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	assert.Empty(t, out.String())
+	_, err = w.WriteBody([]byte("def"))
+	require.NoError(t, err)
+	assert.True(t, w.HeadersWritten())
+	_, err = w.WriteBody([]byte("gh"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n6\r\nabcdef\r\n2\r\ngh\r\n0\r\n\r\n"))
+	assert.False(t, w.CloseAfter())
+
+	// Test: Explicit Content-Length is written through
+	out.Reset()
+	w = NewBufferedWriter(&out, 4)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(6)))
+	assert.True(t, w.HeadersWritten())
+	_, err = w.WriteBody([]byte("abcdef"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Content-Length: 6\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nabcdef"))
+}
@@ -0,0 +1,311 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+	"webserver/internal/server"
+)
+
+type segmentKind int
+
+const (
+	// Kinds are ordered by precedence: when several routes match a path,
+	// the one with the more specific segment wins at the first difference.
+	segmentWildcard segmentKind = iota
+	segmentParam
+	segmentLiteral
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	// trailingSlash is set for patterns such as "/users/" whose last
+	// segment is empty.
+	trailingSlash bool
+	handler       server.Handler
+}
+
+// Router dispatches requests to handlers registered by method and path
+// pattern. Its Serve method is a server.Handler.
+//
+// Patterns are "[METHOD ]/path", where each path segment is either a literal,
+// a parameter such as {id} matching exactly one segment, or a final {name...}
+// wildcard matching the rest of the path after a slash. A pattern without a method matches every method, and a GET
+// pattern also matches HEAD unless a HEAD pattern fits better.
+type Router struct {
+	routes []*route
+
+	// RedirectTrailingSlash answers a request whose path only differs from a
+	// registered pattern by a trailing slash with a 308 to that pattern's
+	// form. When unset such requests get a 404.
+	RedirectTrailingSlash bool
+}
+
+func New() *Router {
+	return &Router{
+		RedirectTrailingSlash: true,
+	}
+}
+
+// Handle registers handler for pattern. It panics if the pattern is
+// malformed or already registered, since that is a programming error.
+func (r *Router) Handle(pattern string, handler server.Handler) {
+	rt, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("router: %v", err))
+	}
+	for _, existing := range r.routes {
+		if existing.method == rt.method && samePath(existing, rt) {
+			panic(fmt.Sprintf("router: pattern %q conflicts with %q", pattern, existing.pattern))
+		}
+	}
+	rt.handler = handler
+	r.routes = append(r.routes, rt)
+}
+
+func parsePattern(pattern string) (*route, error) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("pattern %q must start with /", pattern)
+	}
+
+	rt := &route{
+		method:  method,
+		pattern: pattern,
+	}
+	parts := strings.Split(path[1:], "/")
+	if len(parts) > 1 && parts[len(parts)-1] == "" {
+		rt.trailingSlash = true
+		parts = parts[:len(parts)-1]
+	}
+	names := map[string]bool{}
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("pattern %q has a malformed segment %q", pattern, part)
+			}
+			rt.segments = append(rt.segments, segment{kind: segmentLiteral, value: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		kind := segmentParam
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 || rt.trailingSlash {
+				return nil, fmt.Errorf("pattern %q has a wildcard that is not the last segment", pattern)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = segmentWildcard
+		}
+		if name == "" || names[name] {
+			return nil, fmt.Errorf("pattern %q has an empty or repeated parameter name", pattern)
+		}
+		names[name] = true
+		rt.segments = append(rt.segments, segment{kind: kind, value: name})
+	}
+	return rt, nil
+}
+
+func samePath(a, b *route) bool {
+	if len(a.segments) != len(b.segments) || a.trailingSlash != b.trailingSlash {
+		return false
+	}
+	for i := range a.segments {
+		if a.segments[i].kind != b.segments[i].kind {
+			return false
+		}
+		if a.segments[i].kind == segmentLiteral && a.segments[i].value != b.segments[i].value {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether the route matches the path split into segments, and
+// returns the extracted parameters.
+func (rt *route) match(parts []string, trailingSlash bool) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, seg := range rt.segments {
+		if seg.kind == segmentWildcard {
+			// The wildcard needs the slash before it, so "/files/{path...}"
+			// matches "/files/" but not "/files".
+			if len(parts) <= i && !trailingSlash {
+				return nil, false
+			}
+			rest := strings.Join(parts[i:], "/")
+			if trailingSlash && len(parts) > i {
+				rest += "/"
+			}
+			params[seg.value] = rest
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = parts[i]
+		}
+	}
+	if len(parts) != len(rt.segments) || trailingSlash != rt.trailingSlash {
+		return nil, false
+	}
+	return params, true
+}
+
+// moreSpecific reports whether a should be preferred over b when both match
+// a request for method.
+func moreSpecific(a, b *route, method string) bool {
+	for i := 0; i < len(a.segments) && i < len(b.segments); i++ {
+		if a.segments[i].kind != b.segments[i].kind {
+			return a.segments[i].kind > b.segments[i].kind
+		}
+	}
+	if len(a.segments) != len(b.segments) {
+		return len(a.segments) > len(b.segments)
+	}
+	// A route bound to the method beats a GET route standing in for HEAD,
+	// which beats one that accepts every method.
+	return methodRank(a, method) > methodRank(b, method)
+}
+
+func methodRank(rt *route, method string) int {
+	switch rt.method {
+	case method:
+		return 2
+	case "":
+		return 0
+	}
+	return 1
+}
+
+// accepts reports whether the route handles method. GET routes handle HEAD
+// too, since the response writer drops the body.
+func (rt *route) accepts(method string) bool {
+	return rt.method == "" || rt.method == method || (method == "HEAD" && rt.method == "GET")
+}
+
+// splitPath splits the path of a request target into unescaped segments.
+func splitPath(target string) ([]string, bool, error) {
+	path, _, _ := strings.Cut(target, "?")
+	if !strings.HasPrefix(path, "/") {
+		return nil, false, fmt.Errorf("request target %q is not an absolute path", target)
+	}
+	parts := strings.Split(path[1:], "/")
+	trailingSlash := false
+	if len(parts) > 1 && parts[len(parts)-1] == "" {
+		trailingSlash = true
+		parts = parts[:len(parts)-1]
+	}
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, false, err
+		}
+		parts[i] = unescaped
+	}
+	return parts, trailingSlash, nil
+}
+
+// lookup finds the best route for method among those matching the path.
+// When no route accepts the method, it returns the methods that would have
+// been accepted instead.
+func (r *Router) lookup(method string, parts []string, trailingSlash bool) (*route, map[string]string, []string) {
+	var best *route
+	var bestParams map[string]string
+	allowed := map[string]bool{}
+	for _, rt := range r.routes {
+		params, ok := rt.match(parts, trailingSlash)
+		if !ok {
+			continue
+		}
+		if !rt.accepts(method) {
+			allowed[rt.method] = true
+			if rt.method == "GET" {
+				allowed["HEAD"] = true
+			}
+			continue
+		}
+		if best == nil || moreSpecific(rt, best, method) {
+			best, bestParams = rt, params
+		}
+	}
+	if best != nil {
+		return best, bestParams, nil
+	}
+
+	methods := make([]string, 0, len(allowed))
+	for m := range allowed {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return nil, nil, methods
+}
+
+// Serve dispatches req to the matching handler. When no handler applies it
+// answers a trailing-slash redirect itself, or returns a 404 or 405
+// *server.HandlerError with the Allow header already set.
+func (r *Router) Serve(w *response.Writer, req *request.Request) error {
+	parts, trailingSlash, err := splitPath(req.RequestLine.RequestTarget)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusBadRequest}
+	}
+
+	rt, params, allowed := r.lookup(req.RequestLine.Method, parts, trailingSlash)
+	if rt != nil {
+		req.PathParams = params
+		return rt.handler(w, req)
+	}
+
+	isRoot := len(parts) == 1 && parts[0] == ""
+	if r.RedirectTrailingSlash && len(allowed) == 0 && !isRoot {
+		if alt, _, _ := r.lookup(req.RequestLine.Method, parts, !trailingSlash); alt != nil {
+			h := headers.NewHeaders()
+			h.Set("Location", toggleTrailingSlash(req.RequestLine.RequestTarget))
+			h.Set("Content-Length", "0")
+			w.WriteStatusLine(response.StatusPermanentRedirect)
+			return w.WriteHeaders(h)
+		}
+	}
+
+	if len(allowed) > 0 {
+		w.Headers().Replace("Allow", strings.Join(allowed, ", "))
+		return &server.HandlerError{StatusCode: response.StatusMethodNotAllowed}
+	}
+	return &server.HandlerError{StatusCode: response.StatusNotFound}
+}
+
+func toggleTrailingSlash(target string) string {
+	path, query, hasQuery := strings.Cut(target, "?")
+	if strings.HasSuffix(path, "/") {
+		path = strings.TrimSuffix(path, "/")
+	} else {
+		path += "/"
+	}
+	if hasQuery {
+		return path + "?" + query
+	}
+	return path
+}
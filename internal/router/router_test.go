@@ -0,0 +1,139 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"testing"
+	"webserver/internal/request"
+	"webserver/internal/response"
+	"webserver/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs the router on a request and returns the response it wrote, or
+// the writer with its pending headers and the error the router returned.
+func serve(t *testing.T, r *Router, method, target string) (*http.Response, *request.Request, *response.Writer, error) {
+	t.Helper()
+	req, err := request.RequestFromReader(bytes.NewBufferString(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	if err := r.Serve(w, req); err != nil {
+		return nil, req, w, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	return resp, req, w, nil
+}
+
+func requireStatus(t *testing.T, err error, statusCode response.StatusCode) {
+	t.Helper()
+	var handlerErr *server.HandlerError
+	require.ErrorAs(t, err, &handlerErr)
+	assert.Equal(t, statusCode, handlerErr.StatusCode)
+}
+
+func named(name string) server.Handler {
+	return func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(0)
+		h.Set("X-Route", name)
+		return w.WriteHeaders(h)
+	}
+}
+
+func TestRouterServe(t *testing.T) {
+	r := New()
+	r.Handle("GET /", named("root"))
+	r.Handle("GET /users/{id}", named("user"))
+	r.Handle("DELETE /users/{id}", named("delete-user"))
+	r.Handle("GET /users/me", named("me"))
+	r.Handle("/files/{path...}", named("files"))
+	r.Handle("GET /docs/", named("docs"))
+
+	// Test: Root pattern
+	resp, _, _, _ := serve(t, r, "GET", "/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "root", resp.Header.Get("X-Route"))
+
+	// Test: Path parameter is extracted and unescaped
+	resp, req, _, _ := serve(t, r, "GET", "/users/42%20x?verbose=1")
+	assert.Equal(t, "user", resp.Header.Get("X-Route"))
+	assert.Equal(t, "42 x", req.PathParam("id"))
+
+	// Test: Literal segment wins over a parameter
+	resp, _, _, _ = serve(t, r, "GET", "/users/me")
+	assert.Equal(t, "me", resp.Header.Get("X-Route"))
+
+	// Test: Method selects between routes with the same path
+	resp, req, _, _ = serve(t, r, "DELETE", "/users/7")
+	assert.Equal(t, "delete-user", resp.Header.Get("X-Route"))
+	assert.Equal(t, "7", req.PathParam("id"))
+
+	// Test: Wildcard matches the rest of the path for every method
+	resp, req, _, _ = serve(t, r, "PUT", "/files/a/b/c.txt")
+	assert.Equal(t, "files", resp.Header.Get("X-Route"))
+	assert.Equal(t, "a/b/c.txt", req.PathParam("path"))
+
+	// Test: Wildcard needs the slash before it, and its parent redirects there
+	resp, req, _, _ = serve(t, r, "GET", "/files/")
+	assert.Equal(t, "files", resp.Header.Get("X-Route"))
+	assert.Equal(t, "", req.PathParam("path"))
+	resp, _, _, _ = serve(t, r, "GET", "/files?x=1")
+	assert.Equal(t, 308, resp.StatusCode)
+	assert.Equal(t, "/files/?x=1", resp.Header.Get("Location"))
+
+	// Test: Known path with another method gets 405 and Allow
+	_, _, w, err := serve(t, r, "POST", "/users/7")
+	requireStatus(t, err, response.StatusMethodNotAllowed)
+	allow, _ := w.Headers().Get("Allow")
+	assert.Equal(t, "DELETE, GET, HEAD", allow)
+
+	// Test: GET routes answer HEAD unless a HEAD route fits
+	resp, _, _, _ = serve(t, r, "HEAD", "/users/7")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "user", resp.Header.Get("X-Route"))
+	r.Handle("HEAD /users/{id}", named("head-user"))
+	resp, _, _, _ = serve(t, r, "HEAD", "/users/7")
+	assert.Equal(t, "head-user", resp.Header.Get("X-Route"))
+	resp, _, _, _ = serve(t, r, "HEAD", "/users/me")
+	assert.Equal(t, "me", resp.Header.Get("X-Route"))
+	resp, _, _, _ = serve(t, r, "HEAD", "/files/a.txt")
+	assert.Equal(t, "files", resp.Header.Get("X-Route"))
+
+	// Test: Unknown path gets 404
+	_, _, _, err = serve(t, r, "GET", "/nope")
+	requireStatus(t, err, response.StatusNotFound)
+
+	// Test: Missing trailing slash redirects to the registered form
+	resp, _, _, _ = serve(t, r, "GET", "/docs?page=2")
+	assert.Equal(t, 308, resp.StatusCode)
+	assert.Equal(t, "/docs/?page=2", resp.Header.Get("Location"))
+
+	// Test: Extra trailing slash redirects to the registered form
+	resp, _, _, _ = serve(t, r, "GET", "/users/7/")
+	assert.Equal(t, 308, resp.StatusCode)
+	assert.Equal(t, "/users/7", resp.Header.Get("Location"))
+
+	// Test: Trailing slash mismatch is a 404 when redirects are off
+	r.RedirectTrailingSlash = false
+	_, _, _, err = serve(t, r, "GET", "/users/7/")
+	requireStatus(t, err, response.StatusNotFound)
+}
+
+func TestRouterHandlePanics(t *testing.T) {
+	r := New()
+	r.Handle("GET /users/{id}", named("user"))
+
+	// Test: Conflicting pattern
+	assert.Panics(t, func() { r.Handle("GET /users/{name}", named("other")) })
+
+	// Test: Wildcard that is not last
+	assert.Panics(t, func() { r.Handle("GET /{rest...}/x", named("bad")) })
+
+	// Test: Pattern without a leading slash
+	assert.Panics(t, func() { r.Handle("GET users", named("bad")) })
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+)
+
+// CheckPreconditions evaluates the conditional headers of req against the
+// resource's validators. When the request should be served as usual it adds
+// the validator fields to w's headers and returns false. When the client's
+// copy is current it answers with a 304 itself and returns true, and when a
+// precondition fails it returns a 412 *HandlerError.
+func CheckPreconditions(w *response.Writer, req *request.Request, v response.Validators) (bool, error) {
+	switch response.EvaluatePreconditions(req.RequestLine.Method, req.Headers, v) {
+	case response.PreconditionFailed:
+		return false, &HandlerError{StatusCode: response.StatusPreconditionFailed}
+	case response.PreconditionNotModified:
+		v.SetHeaders(w.Headers())
+		w.WriteStatusLine(response.StatusNotModified)
+		return true, w.WriteHeaders(headers.NewHeaders())
+	}
+	v.SetHeaders(w.Headers())
+	return false, nil
+}
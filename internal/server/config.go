@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"webserver/internal/request"
+	"webserver/internal/response"
+)
+
+// Config describes a server started by ServeConfig.
+type Config struct {
+	// Addr is the TCP address to listen on, such as ":42069" or
+	// "127.0.0.1:0". It is ignored when Listener is set.
+	Addr string
+	// Listener, if set, is used instead of listening on Addr. The server
+	// takes ownership of it and closes it on Close or Shutdown.
+	Listener net.Listener
+	Handler  Handler
+
+	// Timeouts defaults to DefaultTimeouts when nil.
+	Timeouts *Timeouts
+	// Limits defaults to request.DefaultLimits when nil.
+	Limits *request.Limits
+	// MaxConns bounds the number of open connections. Once it is reached
+	// the server stops accepting until a connection closes. Zero means no
+	// limit.
+	MaxConns int
+	// Logger receives the server's own messages, such as recovered panics
+	// and handler errors. It defaults to the standard logger.
+	Logger *log.Logger
+	// TLSConfig, if set, makes the server accept TLS connections only.
+	TLSConfig *tls.Config
+	// CertFile and KeyFile name a PEM certificate chain and its private key.
+	// Setting them serves TLS, adding the certificate to TLSConfig if that
+	// is also set.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile names PEM certificates used to verify client
+	// certificates. Setting it requires clients to present a valid
+	// certificate unless ClientAuth says otherwise.
+	ClientCAFile string
+	// ClientAuth, if set, overrides the client certificate policy, for
+	// example tls.VerifyClientCertIfGiven to make mutual TLS optional.
+	ClientAuth tls.ClientAuthType
+	// StreamRequestBody hands request bodies to the handler unread, as
+	// described in SetStreamRequestBody.
+	StreamRequestBody bool
+	// DecodeRequestBody hands gzip and deflate request bodies to the
+	// handler decoded, up to Limits.MaxDecodedBodyBytes. Requests with other
+	// content codings get a 415.
+	DecodeRequestBody bool
+	// HeaderPolicy decides whether response header and trailer fields that
+	// could inject extra lines fail the write or are sanitized. The default
+	// rejects them, which turns into a 500 if the handler returns the error.
+	HeaderPolicy response.HeaderPolicy
+	// ErrorPages defaults to DefaultErrorPages when nil.
+	ErrorPages *ErrorPages
+}
+
+// ServeConfig starts a server as described by cfg. It returns once the server
+// is listening; connections are served in the background.
+func ServeConfig(cfg Config) (*Server, error) {
+	if cfg.Handler == nil {
+		return nil, fmt.Errorf("server config has no handler")
+	}
+	if cfg.MaxConns < 0 {
+		return nil, fmt.Errorf("server config has a negative MaxConns")
+	}
+
+	tlsConfig, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := cfg.Listener
+	if listener == nil {
+		listener, err = net.Listen("tcp", cfg.Addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	server := &Server{
+		closed:       false,
+		handler:      cfg.Handler,
+		timeouts:     DefaultTimeouts,
+		limits:       request.DefaultLimits,
+		streamBody:   cfg.StreamRequestBody,
+		decodeBody:   cfg.DecodeRequestBody,
+		headerPolicy: cfg.HeaderPolicy,
+		listener:     listener,
+		logger:       cfg.Logger,
+		conns:        map[io.ReadWriteCloser]connState{},
+		errorPages:   cfg.ErrorPages,
+	}
+	if cfg.Timeouts != nil {
+		server.timeouts = *cfg.Timeouts
+	}
+	if cfg.Limits != nil {
+		server.limits = *cfg.Limits
+	}
+	if cfg.MaxConns > 0 {
+		server.connSlots = make(chan struct{}, cfg.MaxConns)
+	}
+	go listen(server, listener)
+	return server, nil
+}
+
+// Addr returns the address the server is listening on, which tells callers
+// the port picked when listening on port 0.
+func Addr(s *Server) net.Addr {
+	return s.listener.Addr()
+}
+
+func acquireConnSlot(s *Server) {
+	if s.connSlots != nil {
+		s.connSlots <- struct{}{}
+	}
+}
+
+func releaseConnSlot(s *Server) {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+func logf(s *Server, format string, args ...any) {
+	if s.logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	s.logger.Printf(format, args...)
+}
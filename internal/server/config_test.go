@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"webserver/internal/request"
+	"webserver/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dial(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", Addr(s).String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+// flakyListener fails its first accepts the way a listener out of file
+// descriptors does.
+type flakyListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	}
+	return l.Listener.Accept()
+}
+
+func TestServeConfig(t *testing.T) {
+	// Test: Listening on port 0 reports the chosen address
+	s, err := ServeConfig(Config{Addr: "127.0.0.1:0", Handler: echoTargetHandler})
+	require.NoError(t, err)
+	defer Close(s)
+	assert.NotZero(t, Addr(s).(*net.TCPAddr).Port)
+
+	conn, r := dial(t, s)
+	conn.Write([]byte("GET /config HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	_, body := readResponse(t, r)
+	assert.Equal(t, "/config", body)
+
+	// Test: Injected listener, limits and logger are used
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var logs bytes.Buffer
+	s, err = ServeConfig(Config{
+		Listener: listener,
+		Handler: func(w *response.Writer, req *request.Request) error {
+			panic("boom")
+		},
+		Limits: &request.Limits{MaxRequestLineBytes: 24},
+		Logger: log.New(&logs, "", 0),
+	})
+	require.NoError(t, err)
+	defer Close(s)
+	assert.Equal(t, listener.Addr(), Addr(s))
+
+	conn, r = dial(t, s)
+	conn.Write([]byte("GET /a-target-that-is-too-long HTTP/1.1\r\n\r\n"))
+	resp, _ := readResponse(t, r)
+	assert.Equal(t, 414, resp.StatusCode)
+
+	conn, r = dial(t, s)
+	conn.Write([]byte("GET /panic HTTP/1.1\r\n\r\n"))
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Contains(t, logs.String(), "panic serving GET /panic: boom")
+
+	// Test: Config without a handler is rejected
+	_, err = ServeConfig(Config{Addr: "127.0.0.1:0"})
+	assert.Error(t, err)
+}
+
+func TestServeConfigMaxConns(t *testing.T) {
+	s, err := ServeConfig(Config{Addr: "127.0.0.1:0", Handler: echoTargetHandler, MaxConns: 1})
+	require.NoError(t, err)
+	defer Close(s)
+
+	// Test: Connection over the limit waits until another one closes
+	first, firstReader := dial(t, s)
+	first.Write([]byte("GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	_, body := readResponse(t, firstReader)
+	assert.Equal(t, "/first", body)
+
+	second, secondReader := dial(t, s)
+	second.Write([]byte("GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = secondReader.Peek(1)
+	require.Error(t, err)
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	_, body = readResponse(t, secondReader)
+	assert.Equal(t, "/second", body)
+}
+
+func TestServeConfigDecodeRequestBody(t *testing.T) {
+	bodyHandler := func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+		_, err := w.WriteBody(req.Body)
+		return err
+	}
+	s, err := ServeConfig(Config{Addr: "127.0.0.1:0", Handler: bodyHandler, DecodeRequestBody: true})
+	require.NoError(t, err)
+	defer Close(s)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("hello gzip"))
+	gz.Close()
+
+	// Test: gzip body reaches the handler decoded
+	conn, r := dial(t, s)
+	conn.Write([]byte(fmt.Sprintf("POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n", compressed.Len())))
+	conn.Write(compressed.Bytes())
+	resp, body := readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello gzip", body)
+
+	// Test: Unsupported coding is a 415
+	conn.Write([]byte("POST / HTTP/1.1\r\nContent-Encoding: br\r\nContent-Length: 3\r\n\r\nabc"))
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 415, resp.StatusCode)
+	assert.True(t, resp.Close)
+
+	// Test: Corrupt body is a 400
+	conn, r = dial(t, s)
+	conn.Write([]byte("POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: 3\r\n\r\nabc"))
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestServeConfigAcceptErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	flaky := &flakyListener{Listener: listener}
+	flaky.failures.Store(3)
+	s, err := ServeConfig(Config{
+		Listener: flaky,
+		Handler:  echoTargetHandler,
+		Logger:   log.New(io.Discard, "", 0),
+	})
+	require.NoError(t, err)
+	defer Close(s)
+
+	// Test: Accept errors are retried instead of stopping the server
+	conn, r := dial(t, s)
+	conn.Write([]byte("GET /retried HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	_, body := readResponse(t, r)
+	assert.Equal(t, "/retried", body)
+	assert.Less(t, flaky.failures.Load(), int32(0))
+}
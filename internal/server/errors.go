@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	htmltemplate "html/template"
+	"strconv"
+	texttemplate "text/template"
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+)
+
+type HandlerError struct {
+	StatusCode response.StatusCode
+	Message    string
+}
+
+func (e *HandlerError) Error() string {
+	if e.Message == "" {
+		return response.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+// ErrorPageData is what error page templates are executed with.
+type ErrorPageData struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+// ErrorPages renders the body of error responses in each format the server
+// can negotiate. A nil template falls back to the default for its format.
+type ErrorPages struct {
+	HTML *htmltemplate.Template
+	JSON *texttemplate.Template
+	Text *texttemplate.Template
+}
+
+var defaultHTMLErrorPage = htmltemplate.Must(htmltemplate.New("html").Parse(`<html>
+  <head>
+    <title>{{.StatusCode}} {{.Status}}</title>
+  </head>
+  <body>
+    <h1>{{.Status}}</h1>
+    <p>{{.Message}}</p>
+  </body>
+</html>`))
+
+// ErrorTemplateFuncs are available to JSON and text error templates. The
+// json function encodes a value as a JSON literal.
+var ErrorTemplateFuncs = texttemplate.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+var defaultJSONErrorPage = texttemplate.Must(texttemplate.New("json").Funcs(ErrorTemplateFuncs).Parse(
+	`{"status":{{.StatusCode}},"error":{{json .Status}},"message":{{json .Message}}}` + "\n"))
+
+var defaultTextErrorPage = texttemplate.Must(texttemplate.New("text").Parse(
+	"{{.StatusCode}} {{.Status}}\n{{.Message}}\n"))
+
+// DefaultErrorPages is used by servers that were not given their own.
+var DefaultErrorPages = &ErrorPages{
+	HTML: defaultHTMLErrorPage,
+	JSON: defaultJSONErrorPage,
+	Text: defaultTextErrorPage,
+}
+
+// SetErrorPages replaces the templates used to render error responses.
+func SetErrorPages(s *Server, pages *ErrorPages) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorPages = pages
+}
+
+func errorPages(s *Server) *ErrorPages {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.errorPages == nil {
+		return DefaultErrorPages
+	}
+	return s.errorPages
+}
+
+// errorStatus maps a handler error to the status code it is reported with.
+// Errors from reading a streamed request body are the client's fault.
+func errorStatus(err error) response.StatusCode {
+	var handlerErr *HandlerError
+	switch {
+	case errors.As(err, &handlerErr) && handlerErr.StatusCode >= 400 && handlerErr.StatusCode <= 599:
+		return handlerErr.StatusCode
+	case errors.Is(err, request.ErrorBodyTooLarge), errors.Is(err, request.ErrorDecodedBodyTooLarge):
+		return response.StatusContentTooLarge
+	case errors.Is(err, request.ErrorMalformedContentEncoding):
+		return response.StatusBadRequest
+	case isTimeout(err):
+		return response.StatusRequestTimeout
+	}
+	return response.StatusInternalServerError
+}
+
+// errorMessage is the message shown to the client. Only a HandlerError's
+// message is shown; other errors may carry internal details.
+func errorMessage(err error) string {
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) && handlerErr.Message != "" {
+		return handlerErr.Message
+	}
+	return response.StatusText(errorStatus(err))
+}
+
+var errorMediaTypes = []string{"text/html", "application/json", "text/plain"}
+
+// negotiateErrorType picks the error body format from the Accept header,
+// preferring HTML when the client has no preference.
+func negotiateErrorType(h *headers.Headers) string {
+	accepted := h.Accept("Accept")
+	if len(accepted) == 0 {
+		return "text/html"
+	}
+	best, bestQ := "", 0.0
+	for _, mediaType := range errorMediaTypes {
+		if q := headers.MediaTypeQuality(accepted, mediaType); q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+	if best == "" {
+		return "text/plain"
+	}
+	return best
+}
+
+func renderErrorPage(pages *ErrorPages, mediaType string, data ErrorPageData) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch mediaType {
+	case "text/html":
+		tmpl := pages.HTML
+		if tmpl == nil {
+			tmpl = defaultHTMLErrorPage
+		}
+		err = tmpl.Execute(&buf, data)
+	case "application/json":
+		tmpl := pages.JSON
+		if tmpl == nil {
+			tmpl = defaultJSONErrorPage
+		}
+		err = tmpl.Execute(&buf, data)
+	default:
+		tmpl := pages.Text
+		if tmpl == nil {
+			tmpl = defaultTextErrorPage
+		}
+		err = tmpl.Execute(&buf, data)
+	}
+	return buf.Bytes(), err
+}
+
+// writeError turns a handler error into an error response. Errors other
+// than a HandlerError are logged, since the client only sees a generic 500.
+// If part of the response already reached the client, the connection is
+// aborted instead.
+func writeError(s *Server, w *response.Writer, req *request.Request, err error) {
+	statusCode := errorStatus(err)
+	var handlerErr *HandlerError
+	if !errors.As(err, &handlerErr) {
+		logf(s, "error serving %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+	}
+	if !w.Reset() {
+		w.Abort()
+		return
+	}
+
+	mediaType := negotiateErrorType(req.Headers)
+	body, renderErr := renderErrorPage(errorPages(s), mediaType, ErrorPageData{
+		StatusCode: int(statusCode),
+		Status:     response.StatusText(statusCode),
+		Message:    errorMessage(err),
+	})
+	if renderErr != nil {
+		logf(s, "error rendering %s error page: %v", mediaType, renderErr)
+		mediaType = "text/plain"
+		body = []byte(response.StatusText(statusCode) + "\n")
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", mediaType+"; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"io"
+	"testing"
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerErrors(t *testing.T) {
+	failWith := func(err error) Handler {
+		return func(w *response.Writer, req *request.Request) error {
+			return err
+		}
+	}
+
+	// Test: HandlerError picks the status and message, HTML by default
+	resp, _ := serveOnce(t, failWith(&HandlerError{StatusCode: response.StatusTooManyRequests, Message: "slow <down>"}),
+		"GET / HTTP/1.1\r\n\r\n")
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, 429, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "<title>429 Too Many Requests</title>")
+	assert.Contains(t, string(body), "<p>slow &lt;down&gt;</p>")
+
+	// Test: JSON is negotiated from Accept
+	resp, _ = serveOnce(t, failWith(&HandlerError{StatusCode: response.StatusNotFound, Message: `no "such" thing`}),
+		"GET / HTTP/1.1\r\nAccept: text/html;q=0.5, application/json\r\n\r\n")
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"status":404,"error":"Not Found","message":"no \"such\" thing"}`, string(body))
+
+	// Test: Equal preferences fall back to HTML
+	resp, _ = serveOnce(t, failWith(&HandlerError{StatusCode: response.StatusBadRequest}),
+		"GET / HTTP/1.1\r\nAccept: text/*\r\n\r\n")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// Test: Plain text is negotiated from Accept
+	resp, _ = serveOnce(t, failWith(&HandlerError{StatusCode: response.StatusBadRequest}),
+		"GET / HTTP/1.1\r\nAccept: text/plain, text/html;q=0.1\r\n\r\n")
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "400 Bad Request\nBad Request\n", string(body))
+
+	// Test: Other errors become a 500 without leaking their text
+	resp, _ = serveOnce(t, failWith(errors.New("database password is hunter2")),
+		"GET / HTTP/1.1\r\nAccept: text/plain\r\n\r\n")
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.NotContains(t, string(body), "hunter2")
+
+	// Test: Buffered output written before the error is discarded
+	resp, _ = serveOnce(t, func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("half a response"))
+		return &HandlerError{StatusCode: response.StatusConflict}
+	}, "GET / HTTP/1.1\r\nAccept: text/plain\r\n\r\n")
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, 409, resp.StatusCode)
+	assert.NotContains(t, string(body), "half a response")
+}
+
+func TestCustomErrorPages(t *testing.T) {
+	s := &Server{}
+	SetErrorPages(s, &ErrorPages{
+		HTML: htmltemplate.Must(htmltemplate.New("custom").Parse("<h1>{{.StatusCode}}</h1><p>{{.Message}}</p>")),
+	})
+
+	// Test: Custom template is used, missing ones fall back to the defaults
+	body, err := renderErrorPage(errorPages(s), "text/html", ErrorPageData{StatusCode: 418, Message: "short and stout"})
+	assert.NoError(t, err)
+	assert.Equal(t, "<h1>418</h1><p>short and stout</p>", string(body))
+
+	body, err = renderErrorPage(errorPages(s), "text/plain", ErrorPageData{StatusCode: 418, Status: "", Message: "short and stout"})
+	assert.NoError(t, err)
+	assert.Equal(t, "418 \nshort and stout\n", string(body))
+}
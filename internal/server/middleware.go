@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"runtime/debug"
+	"time"
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+)
+
+// RequestIDHeader carries the request ID assigned by the RequestID
+// middleware, on both the request and the response.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds client-supplied request IDs that are reused
+// instead of generating a new one.
+const maxRequestIDLength = 128
+
+// Middleware wraps a Handler with cross-cutting behavior.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares. The first middleware is the
+// outermost, so it sees the request first and the response last.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Logger logs one line per request with its method, target, status code,
+// body size and duration. A nil logger uses the standard logger.
+func Logger(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) error {
+			start := time.Now()
+			err := next(w, req)
+			statusCode := w.StatusCode()
+			if err != nil {
+				statusCode = errorStatus(err)
+			}
+			id, _ := req.Headers.Get(RequestIDHeader)
+			logger.Printf("%s %s %d %dB %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget,
+				statusCode, w.BodyBytes(), time.Since(start).Round(time.Microsecond), id)
+			return err
+		}
+	}
+}
+
+// Recover turns a panicking handler into a 500 error and logs the panic with
+// its stack trace. If the headers were already sent, the response is aborted
+// instead. A nil logger uses the standard logger.
+func Recover(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) (err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					logger.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+					w.Headers().Replace("Connection", "close")
+					err = &HandlerError{StatusCode: response.StatusInternalServerError}
+				}
+			}()
+			return next(w, req)
+		}
+	}
+}
+
+// Compress compresses eligible response bodies with the best content coding
+// the client accepts, as described in response.Writer.Compress. Bodies known
+// to be smaller than minSize are sent as is; zero uses
+// response.DefaultCompressionMinSize.
+func Compress(minSize int) Middleware {
+	if minSize <= 0 {
+		minSize = response.DefaultCompressionMinSize
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) error {
+			acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+			w.Compress(response.NegotiateEncoding(acceptEncoding), minSize)
+			return next(w, req)
+		}
+	}
+}
+
+// RequestID makes sure every request carries an ID in its RequestIDHeader,
+// reusing a well-formed one sent by the client, and echoes it on the
+// response.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) error {
+			id, exists := req.Headers.Get(RequestIDHeader)
+			if !exists || len(id) > maxRequestIDLength || !headers.IsToken([]byte(id)) {
+				id = newRequestID()
+				req.Headers.Replace(RequestIDHeader, id)
+			}
+			w.Headers().Replace(RequestIDHeader, id)
+			return next(w, req)
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
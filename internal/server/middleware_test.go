@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"webserver/internal/request"
+	"webserver/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveOnce(t *testing.T, handler Handler, raw string) (*http.Response, *response.Writer) {
+	t.Helper()
+	req, err := request.RequestFromReader(bytes.NewBufferString(raw))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	w := response.NewBufferedWriter(&out, response.DefaultBufferLimit)
+	if err := handler(w, req); err != nil {
+		writeError(&Server{}, w, req, err)
+	}
+	w.Finish()
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	return resp, w
+}
+
+func TestChain(t *testing.T) {
+	order := []string{}
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) error {
+				order = append(order, name+" in")
+				err := next(w, req)
+				order = append(order, name+" out")
+				return err
+			}
+		}
+	}
+
+	// Test: First middleware is the outermost
+	handler := Chain(echoTargetHandler, trace("a"), trace("b"))
+	resp, _ := serveOnce(t, handler, "GET /chain HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []string{"a in", "b in", "b out", "a out"}, order)
+}
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+
+	// Test: Panic before anything is written becomes a 500
+	handler := Chain(func(w *response.Writer, req *request.Request) error {
+		panic("boom")
+	}, Recover(logger))
+	resp, w := serveOnce(t, handler, "GET /panic HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.True(t, w.CloseAfter())
+	assert.Contains(t, logs.String(), "panic serving GET /panic: boom")
+	assert.Contains(t, logs.String(), "goroutine")
+
+	// Test: Panic after the headers were sent aborts the response
+	handler = Chain(func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(10))
+		panic("late boom")
+	}, Recover(logger))
+	resp, w = serveOnce(t, handler, "GET /late HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.True(t, w.CloseAfter())
+}
+
+func TestRequestIDAndLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	handler := Chain(echoTargetHandler, RequestID(), Logger(logger))
+
+	// Test: ID is generated and echoed on the response
+	resp, _ := serveOnce(t, handler, "GET /id HTTP/1.1\r\n\r\n")
+	id := resp.Header.Get(RequestIDHeader)
+	assert.Len(t, id, 32)
+	assert.Contains(t, logs.String(), "GET /id 200 3B")
+	assert.Contains(t, logs.String(), id)
+
+	// Test: Well-formed client ID is reused
+	resp, _ = serveOnce(t, handler, "GET /id HTTP/1.1\r\nX-Request-Id: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", resp.Header.Get(RequestIDHeader))
+
+	// Test: Malformed client ID is replaced
+	resp, _ = serveOnce(t, handler, "GET /id HTTP/1.1\r\nX-Request-Id: not valid\r\n\r\n")
+	assert.NotEqual(t, "not valid", resp.Header.Get(RequestIDHeader))
+	assert.Len(t, resp.Header.Get(RequestIDHeader), 32)
+}
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("squeeze ", 200)
+	handler := Chain(func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(text)))
+		_, err := w.WriteBody([]byte(text))
+		return err
+	}, Compress(0))
+
+	// Test: Accepted coding is applied
+	resp, _ := serveOnce(t, handler, "GET / HTTP/1.1\r\nAccept-Encoding: deflate;q=0.5, gzip\r\n\r\n")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(body))
+
+	// Test: No Accept-Encoding gets the identity body
+	resp, _ = serveOnce(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(body))
+}
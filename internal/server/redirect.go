@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"webserver/internal/headers"
+	"webserver/internal/request"
+	"webserver/internal/response"
+)
+
+// HTTPSRedirect configures RedirectHandler.
+type HTTPSRedirect struct {
+	// HTTPSPort is the port the HTTPS server listens on. Zero or 443 leaves
+	// the port out of redirect URLs.
+	HTTPSPort uint16
+	// Exempt maps path prefixes to local directories whose files are served
+	// over plaintext instead of redirecting, such as
+	// "/.well-known/acme-challenge/" for ACME HTTP-01 challenges. When
+	// prefixes overlap, the longest matching one is used.
+	Exempt map[string]string
+}
+
+// RedirectHandler answers every request with a 308 to the https:// URL built
+// from its Host header and request target, except for files under the
+// exempt prefixes.
+func RedirectHandler(redirect HTTPSRedirect) Handler {
+	return func(w *response.Writer, req *request.Request) error {
+		target := req.RequestLine.RequestTarget
+		if !strings.HasPrefix(target, "/") {
+			return &HandlerError{StatusCode: response.StatusBadRequest, Message: "Request target must be an absolute path."}
+		}
+		if dir, name, ok := exemptFile(redirect.Exempt, target); ok {
+			return serveExemptFile(w, req, dir, name)
+		}
+
+		host, ok := redirectHost(req, redirect.HTTPSPort)
+		if !ok {
+			return &HandlerError{StatusCode: response.StatusBadRequest, Message: "Missing or invalid Host header."}
+		}
+		h := headers.NewHeaders()
+		h.Set("Location", "https://"+host+target)
+		h.Set("Content-Length", "0")
+		w.WriteStatusLine(response.StatusPermanentRedirect)
+		return w.WriteHeaders(h)
+	}
+}
+
+// ServeHTTPSRedirect listens on the given TCP port and redirects everything
+// to HTTPS, like Serve with a RedirectHandler.
+func ServeHTTPSRedirect(port uint16, redirect HTTPSRedirect) (*Server, error) {
+	return ServeConfig(Config{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: RedirectHandler(redirect),
+	})
+}
+
+// redirectHost returns the host to redirect to, taken from the Host header
+// with its port replaced by the HTTPS one. A request must carry exactly one
+// Host field.
+func redirectHost(req *request.Request, httpsPort uint16) (string, bool) {
+	hosts := req.Headers.Values("Host")
+	if len(hosts) != 1 {
+		return "", false
+	}
+	host := hosts[0]
+	if host == "" || strings.ContainsAny(host, "/?#@\\ \t,") {
+		return "", false
+	}
+	if hostname, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", false
+		}
+		host = hostname
+	} else if strings.HasPrefix(host, "[") {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	if host == "" {
+		return "", false
+	}
+
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if httpsPort != 0 && httpsPort != 443 {
+		host += ":" + strconv.Itoa(int(httpsPort))
+	}
+	return host, true
+}
+
+// exemptFile finds the exempt directory covering target and the cleaned
+// file name below it. When prefixes overlap, the longest one wins.
+func exemptFile(exempt map[string]string, target string) (string, string, bool) {
+	p, _, _ := strings.Cut(target, "?")
+	best := ""
+	found := false
+	for prefix := range exempt {
+		if strings.HasPrefix(p, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	if !found {
+		return "", "", false
+	}
+	return exempt[best], path.Clean("/" + strings.TrimPrefix(p, best)), true
+}
+
+func serveExemptFile(w *response.Writer, req *request.Request, dir, name string) error {
+	// The writer drops the body of a response to HEAD.
+	if method := req.RequestLine.Method; method != "GET" && method != "HEAD" {
+		w.Headers().Replace("Allow", "GET, HEAD")
+		return &HandlerError{StatusCode: response.StatusMethodNotAllowed}
+	}
+	file := filepath.Join(dir, filepath.FromSlash(name))
+	info, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || (err == nil && !info.Mode().IsRegular()) {
+		return &HandlerError{StatusCode: response.StatusNotFound}
+	}
+	if err != nil {
+		return err
+	}
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	w.WriteStatusLine(response.StatusOK)
+	if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
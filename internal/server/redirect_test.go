@@ -0,0 +1,81 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("token.key"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(t.TempDir(), "secret"), []byte("secret"), 0o644))
+	handler := RedirectHandler(HTTPSRedirect{
+		Exempt: map[string]string{"/.well-known/acme-challenge/": dir},
+	})
+
+	// Test: Request is redirected to the same host and target over HTTPS
+	resp, _ := serveOnce(t, handler, "POST /login?next=%2Fhome HTTP/1.1\r\nHost: example.com:8080\r\nContent-Length: 0\r\n\r\n")
+	assert.Equal(t, 308, resp.StatusCode)
+	assert.Equal(t, "https://example.com/login?next=%2Fhome", resp.Header.Get("Location"))
+
+	// Test: IPv6 host keeps its brackets and gets the HTTPS port
+	resp, _ = serveOnce(t, RedirectHandler(HTTPSRedirect{HTTPSPort: 8443}), "GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n")
+	assert.Equal(t, "https://[::1]:8443/", resp.Header.Get("Location"))
+
+	// Test: Missing or malformed Host is a 400
+	resp, _ = serveOnce(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+	resp, _ = serveOnce(t, handler, "GET / HTTP/1.1\r\nHost: evil.com/x@example.com\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+	resp, _ = serveOnce(t, handler, "GET * HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+	resp, _ = serveOnce(t, handler, "GET / HTTP/1.1\r\nHost: a\r\nHost: a\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Location"))
+	resp, _ = serveOnce(t, handler, "GET / HTTP/1.1\r\nHost: a,a\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+
+	// Test: Exempt prefix is served from its directory
+	resp, _ = serveOnce(t, handler, "GET /.well-known/acme-challenge/token HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "token.key", string(body))
+
+	// Test: Exempt files that do not exist, directories and traversal are 404s
+	resp, _ = serveOnce(t, handler, "GET /.well-known/acme-challenge/missing HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 404, resp.StatusCode)
+	resp, _ = serveOnce(t, handler, "GET /.well-known/acme-challenge/ HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 404, resp.StatusCode)
+	resp, _ = serveOnce(t, handler, "GET /.well-known/acme-challenge/../secret HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: The longest of overlapping exempt prefixes serves the path
+	wellKnown := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(wellKnown, "acme-challenge"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(wellKnown, "acme-challenge", "token"), []byte("shadowed"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(wellKnown, "security.txt"), []byte("contact"), 0o644))
+	overlapping := RedirectHandler(HTTPSRedirect{
+		Exempt: map[string]string{"/.well-known/": wellKnown, "/.well-known/acme-challenge/": dir},
+	})
+	for i := 0; i < 20; i++ {
+		resp, _ = serveOnce(t, overlapping, "GET /.well-known/acme-challenge/token HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		body, _ = io.ReadAll(resp.Body)
+		assert.Equal(t, "token.key", string(body))
+	}
+	resp, _ = serveOnce(t, overlapping, "GET /.well-known/security.txt HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "contact", string(body))
+
+	// Test: Exempt files are only served to GET and HEAD
+	resp, _ = serveOnce(t, handler, "HEAD /.well-known/acme-challenge/token HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(len("token.key")), resp.ContentLength)
+	resp, _ = serveOnce(t, handler, "DELETE /.well-known/acme-challenge/token HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+}
@@ -1,37 +1,130 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
+	"runtime/debug"
+	"sync"
+	"time"
 	"webserver/internal/request"
 	"webserver/internal/response"
 )
 
-type Server struct {
-	closed  bool
-	handler Handler
+// DefaultIdleTimeout is how long a persistent connection may sit between
+// requests before the server closes it.
+const DefaultIdleTimeout = 2 * time.Minute
+
+// timeoutWriteGrace bounds how long the server spends telling a client that
+// its request timed out.
+const timeoutWriteGrace = 5 * time.Second
+
+// Timeouts bounds how long each phase of a connection may take. Zero disables
+// a timeout.
+type Timeouts struct {
+	// ReadHeader covers the request line and headers, from the first byte of
+	// the request. It also bounds the wait for the first request on a new
+	// connection.
+	ReadHeader time.Duration
+	// ReadBody covers the request body once the headers are in.
+	ReadBody time.Duration
+	// Write covers running the handler and writing the response.
+	Write time.Duration
+	// Idle is how long a persistent connection may wait for its next request.
+	Idle time.Duration
 }
 
-type HandlerError struct {
-	StatusCode response.StatusCode
-	Message    string
+// DefaultTimeouts guard against clients that hold connections open without
+// sending anything, while leaving slow downloads alone.
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 10 * time.Second,
+	ReadBody:   time.Minute,
+	Idle:       DefaultIdleTimeout,
+}
+
+// shutdownPollInterval is how often Shutdown checks whether the active
+// connections have finished.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Accept errors other than a closed listener, such as running out of file
+// descriptors, are retried after a delay that doubles from minAcceptBackoff
+// up to maxAcceptBackoff.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+type connState int
+
+const (
+	// connStateIdle is a connection waiting for its next request. It can be
+	// closed at any time without losing work.
+	connStateIdle connState = iota
+	// connStateActive is a connection whose request is being handled.
+	connStateActive
+)
+
+type Server struct {
+	closed   bool
+	handler  Handler
+	timeouts Timeouts
+	limits   request.Limits
+	// streamBody hands request bodies to handlers unread instead of
+	// buffering them first.
+	streamBody bool
+	// decodeBody decodes gzip and deflate request bodies.
+	decodeBody bool
+	// headerPolicy is applied to every response writer.
+	headerPolicy response.HeaderPolicy
+	listener     net.Listener
+	logger       *log.Logger
+	// connSlots holds a token per open connection when the number of
+	// connections is limited.
+	connSlots chan struct{}
+
+	mu         sync.Mutex
+	conns      map[io.ReadWriteCloser]connState
+	errorPages *ErrorPages
 }
 
-type Handler func(w *response.Writer, req *request.Request)
+// Handler writes the response to req. Returning an error instead sends an
+// error response: a *HandlerError picks the status code and message, and
+// any other error becomes a 500.
+type Handler func(w *response.Writer, req *request.Request) error
 
 func listen(s *Server, listener net.Listener) error {
 
 	go func() {
+		backoff := time.Duration(0)
 		for {
+			acquireConnSlot(s)
 			conn, err := listener.Accept()
-			if s.closed {
+			if isClosed(s) {
+				if conn != nil {
+					conn.Close()
+				}
+				releaseConnSlot(s)
 				return
 			}
 			if err != nil {
-				return
+				releaseConnSlot(s)
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+				logf(s, "accept error: %v; retrying in %v", err, backoff)
+				time.Sleep(backoff)
+				continue
 			}
-			go runConnection(s, conn)
+			backoff = 0
+			setConnState(s, conn, connStateIdle)
+			go func() {
+				defer releaseConnSlot(s)
+				runConnection(s, conn)
+			}()
 		}
 	}()
 
@@ -40,31 +133,310 @@ func listen(s *Server, listener net.Listener) error {
 
 func runConnection(s *Server, conn io.ReadWriteCloser) {
 	defer conn.Close()
+	defer forgetConn(s, conn)
+	defer func() {
+		if v := recover(); v != nil {
+			logf(s, "panic in connection: %v\n%s", v, debug.Stack())
+		}
+	}()
 
-	responseWriter := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		responseWriter.WriteStatusLine(response.StatusBadRequest)
-		responseWriter.WriteHeaders(response.GetDefaultHeaders(0))
+	timeouts := connTimeouts(s)
+	tlsState, ok := handshake(s, conn, timeouts)
+	if !ok {
 		return
 	}
-	s.handler(responseWriter, req)
+	reader := request.NewLimitedReader(conn, connLimits(s))
+	reader.DecodeContent = s.decodeBody
+	for first := true; ; first = false {
+		waitTimeout := timeouts.Idle
+		if first {
+			waitTimeout = timeouts.ReadHeader
+		}
+		setReadDeadline(conn, waitTimeout)
+		if err := reader.WaitForRequest(); err != nil {
+			return
+		}
+		if !setConnState(s, conn, connStateActive) {
+			return
+		}
+
+		responseWriter := response.NewBufferedWriter(conn, response.DefaultBufferLimit)
+		responseWriter.SetHeaderPolicy(s.headerPolicy)
+		req, err := readRequest(s, conn, reader, timeouts)
+		if err != nil {
+			writeReadError(s, conn, responseWriter, err)
+			return
+		}
+		req.TLS = tlsState
+		if req.RequestLine.Method == "HEAD" {
+			responseWriter.OmitBody()
+		}
+
+		setWriteDeadline(conn, timeouts.Write)
+		serveRequest(s, responseWriter, req)
+		if err := responseWriter.Finish(); err != nil {
+			return
+		}
+		setWriteDeadline(conn, 0)
+		// Drain what the handler left of a streamed body so the next request
+		// can be read. A body that ended with an error leaves the stream in an
+		// unknown state.
+		if err := req.BodyReader.Close(); err != nil {
+			return
+		}
+		setReadDeadline(conn, 0)
+
+		if req.Headers.HasToken("Connection", "close") || responseWriter.CloseAfter() {
+			return
+		}
+		if !setConnState(s, conn, connStateIdle) {
+			return
+		}
+	}
 }
 
-func Serve(port uint16, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// readRequest reads the next request from the connection. A streamed body is
+// read by the handler while the body read deadline is still in place.
+func readRequest(s *Server, conn io.ReadWriteCloser, reader *request.Reader, timeouts Timeouts) (*request.Request, error) {
+	setReadDeadline(conn, timeouts.ReadHeader)
+	if streamsBody(s) {
+		req, err := reader.ReadStreamingRequest()
+		setReadDeadline(conn, timeouts.ReadBody)
+		return req, err
+	}
+	req, err := reader.ReadHeaders()
 	if err != nil {
 		return nil, err
 	}
-	server := &Server{
-		closed:  false,
-		handler: handler,
+	setReadDeadline(conn, timeouts.ReadBody)
+	if err := reader.ReadBody(req); err != nil {
+		return nil, err
 	}
-	go listen(server, listener)
-	return server, nil
+	setReadDeadline(conn, 0)
+	return req, nil
 }
 
-func Close(s *Server) error {
+// writeReadError answers a request that could not be read: a 408 if the
+// client was too slow, a 400 if the request was malformed. Nothing is sent
+// when the server is shutting down.
+func writeReadError(s *Server, conn io.ReadWriteCloser, w *response.Writer, err error) {
+	if isClosed(s) {
+		return
+	}
+	statusCode := response.StatusBadRequest
+	switch {
+	case isTimeout(err):
+		statusCode = response.StatusRequestTimeout
+	case errors.Is(err, request.ErrorRequestLineTooLong):
+		statusCode = response.StatusURITooLong
+	case errors.Is(err, request.ErrorHeadersTooLarge):
+		statusCode = response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrorBodyTooLarge), errors.Is(err, request.ErrorDecodedBodyTooLarge):
+		statusCode = response.StatusContentTooLarge
+	case errors.Is(err, request.ErrorUnsupportedContentEncoding):
+		statusCode = response.StatusUnsupportedMediaType
+	case errors.Is(err, request.ErrorUnsupportedTransferEncoding):
+		statusCode = response.StatusNotImplemented
+	}
+	setWriteDeadline(conn, timeoutWriteGrace)
+
+	body := []byte(fmt.Sprintf("%d %s\n", statusCode, response.StatusText(statusCode)))
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Connection", "close")
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+// deadlineConn is implemented by connections that support timeouts, such as
+// net.Conn.
+type deadlineConn interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// deadline returns the time timeout from now, or the zero time, meaning no
+// deadline, when timeout is not positive.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+func setReadDeadline(conn io.ReadWriteCloser, timeout time.Duration) {
+	if dc, ok := conn.(deadlineConn); ok {
+		dc.SetReadDeadline(deadline(timeout))
+	}
+}
+
+func setWriteDeadline(conn io.ReadWriteCloser, timeout time.Duration) {
+	if dc, ok := conn.(deadlineConn); ok {
+		dc.SetWriteDeadline(deadline(timeout))
+	}
+}
+
+// SetTimeouts changes the timeouts applied to connections accepted from now
+// on.
+func SetTimeouts(s *Server, timeouts Timeouts) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeouts = timeouts
+}
+
+func connTimeouts(s *Server) Timeouts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.timeouts
+}
+
+// SetLimits changes the request size limits applied to connections accepted
+// from now on.
+func SetLimits(s *Server, limits request.Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+}
+
+func connLimits(s *Server) request.Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits
+}
+
+// SetStreamRequestBody makes requests accepted from now on reach the handler
+// as soon as their headers are read, with the body left to be read from
+// Request.BodyReader. Whatever the handler does not read is drained before
+// the next request on the connection.
+func SetStreamRequestBody(s *Server, stream bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streamBody = stream
+}
+
+func streamsBody(s *Server) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streamBody
+}
+
+// serveRequest runs the handler and turns a returned error into an error
+// response. A panic is recovered and reported as a 500 if nothing has been
+// sent yet; otherwise the response is aborted. Either way the connection is
+// closed afterwards, since the handler may have left it in a bad state.
+func serveRequest(s *Server, w *response.Writer, req *request.Request) {
+	defer func() {
+		if v := recover(); v != nil {
+			logf(s, "panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+			w.Headers().Replace("Connection", "close")
+			writeError(s, w, req, &HandlerError{StatusCode: response.StatusInternalServerError})
+			w.Abort()
+		}
+	}()
+
+	if err := s.handler(w, req); err != nil {
+		writeError(s, w, req, err)
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isClosed(s *Server) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// setConnState records the state of a tracked connection. It returns false
+// if the server is closed, in which case the connection should not go on to
+// serve another request.
+func setConnState(s *Server, conn io.ReadWriteCloser, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = map[io.ReadWriteCloser]connState{}
+	}
+	s.conns[conn] = state
+	return !s.closed
+}
+
+func forgetConn(s *Server, conn io.ReadWriteCloser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// closeConns closes the tracked connections, or only the idle ones when
+// idleOnly is set, and reports how many connections remain open.
+func closeConns(s *Server, idleOnly bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if idleOnly && state != connStateIdle {
+			continue
+		}
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return len(s.conns)
+}
+
+// stopListening marks the server as closed and closes its listener so that
+// no new connections are accepted.
+func stopListening(s *Server) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.closed = true
-	return nil
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Serve listens on the given TCP port on all interfaces with the default
+// configuration. Use ServeConfig for anything else.
+func Serve(port uint16, handler Handler) (*Server, error) {
+	return ServeConfig(Config{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
+	})
+}
+
+// Close stops the listener and immediately closes every open connection,
+// including those in the middle of a request. Use Shutdown to let in-flight
+// requests finish.
+func Close(s *Server) error {
+	err := stopListening(s)
+	closeConns(s, false)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the listener, closes idle connections and then waits for
+// the active ones to finish their current request. If ctx expires first,
+// Shutdown returns the context's error and leaves the remaining connections
+// open; call Close to force them shut.
+func Shutdown(ctx context.Context, s *Server) error {
+	err := stopListening(s)
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if closeConns(s, true) == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,369 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"webserver/internal/request"
+	"webserver/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoTargetHandler(w *response.Writer, req *request.Request) error {
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	_, err := w.WriteBody(body)
+	return err
+}
+
+// startConnection runs handler on one end of an in-memory connection and
+// returns the client end along with a reader for its responses.
+func startConnection(t *testing.T, s *Server) (net.Conn, *bufio.Reader, <-chan struct{}) {
+	t.Helper()
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		runConnection(s, conn)
+		close(done)
+	}()
+	t.Cleanup(func() { client.Close() })
+	return client, bufio.NewReader(client), done
+}
+
+func readResponse(t *testing.T, r *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp, string(body)
+}
+
+func TestRunConnectionKeepAlive(t *testing.T) {
+	s := &Server{handler: echoTargetHandler, timeouts: DefaultTimeouts}
+
+	// Test: Consecutive requests are served on the same connection
+	client, r, done := startConnection(t, s)
+	go client.Write([]byte("GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, body := readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/one", body)
+
+	go client.Write([]byte("GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, body = readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/two", body)
+
+	// Test: HEAD gets the Content-Length without the body
+	go client.Write([]byte("HEAD /head HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, err := http.ReadResponse(r, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(len("/head")), resp.ContentLength)
+
+	// Test: Connection: close ends the connection after the response
+	go client.Write([]byte("GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	_, body = readResponse(t, r)
+	assert.Equal(t, "/three", body)
+	<-done
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Malformed request gets a 400 and the connection is closed
+	client, r, done = startConnection(t, s)
+	go client.Write([]byte("GET /\r\n\r\n"))
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 400, resp.StatusCode)
+	<-done
+}
+
+func TestShutdownDrainsActiveConnections(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) error {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		return echoTargetHandler(w, req)
+	})
+	require.NoError(t, err)
+	defer Close(s)
+	addr := s.listener.Addr().String()
+
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	idle.Write([]byte("GET /idle HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	idleReader := bufio.NewReader(idle)
+	_, body := readResponse(t, idleReader)
+	assert.Equal(t, "/idle", body)
+
+	active, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer active.Close()
+	active.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	<-started
+
+	// Test: Shutdown times out while a handler is still running
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = Shutdown(ctx, s)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: Idle connections are closed and new ones are refused
+	_, err = idleReader.ReadByte()
+	assert.Error(t, err)
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+
+	// Test: Shutdown returns once the active handler finishes its response
+	shutdownDone := make(chan error)
+	go func() {
+		shutdownDone <- Shutdown(context.Background(), s)
+	}()
+	close(release)
+	_, body = readResponse(t, bufio.NewReader(active))
+	assert.Equal(t, "/slow", body)
+	assert.NoError(t, <-shutdownDone)
+}
+
+func TestRunConnectionRecoversPanics(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) error {
+		switch req.RequestLine.RequestTarget {
+		case "/panic":
+			panic("handler exploded")
+		case "/late-panic":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(100))
+			w.WriteBody([]byte("partial"))
+			panic("handler exploded late")
+		}
+		return echoTargetHandler(w, req)
+	})
+	require.NoError(t, err)
+	defer Close(s)
+	addr := s.listener.Addr().String()
+
+	// Test: Panic before anything was sent becomes a 500 and closes the connection
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /panic HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, _ := readResponse(t, r)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.True(t, resp.Close)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Contains(t, logs.String(), "panic serving GET /panic: handler exploded")
+	assert.Contains(t, logs.String(), "runtime/debug.Stack")
+
+	// Test: Panic after the headers were sent aborts the connection
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /late-panic HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Server keeps serving new connections
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /still-alive HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	_, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "/still-alive", body)
+}
+
+func TestRunConnectionTimeouts(t *testing.T) {
+	s := &Server{handler: echoTargetHandler, timeouts: Timeouts{
+		ReadHeader: 50 * time.Millisecond,
+		ReadBody:   50 * time.Millisecond,
+		Write:      50 * time.Millisecond,
+		Idle:       50 * time.Millisecond,
+	}}
+	waitDone := func(done <-chan struct{}) {
+		t.Helper()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("connection was not closed after its timeout")
+		}
+	}
+
+	// Test: Client that never sends anything is disconnected without a response
+	client, r, done := startConnection(t, s)
+	waitDone(done)
+	_, err := r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Client that stalls in the middle of the headers gets a 408
+	client, r, done = startConnection(t, s)
+	go client.Write([]byte("GET /slow HTTP/1.1\r\nHost: loc"))
+	resp, _ := readResponse(t, r)
+	assert.Equal(t, 408, resp.StatusCode)
+	assert.True(t, resp.Close)
+	waitDone(done)
+
+	// Test: Client that stalls in the middle of the body gets a 408
+	client, r, done = startConnection(t, s)
+	go client.Write([]byte("POST /slow HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc"))
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 408, resp.StatusCode)
+	waitDone(done)
+
+	// Test: Idle keep-alive connection is closed after its timeout
+	client, r, done = startConnection(t, s)
+	go client.Write([]byte("GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	_, body := readResponse(t, r)
+	assert.Equal(t, "/first", body)
+	waitDone(done)
+
+	// Test: Client that never reads the response is disconnected
+	s.handler = func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(1 << 20))
+		_, err := w.WriteBody(make([]byte, 1<<20))
+		return err
+	}
+	client, _, done = startConnection(t, s)
+	client.Write([]byte("GET /big HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	waitDone(done)
+}
+
+func TestRunConnectionLimits(t *testing.T) {
+	s := &Server{handler: echoTargetHandler, timeouts: DefaultTimeouts, limits: request.Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes:      64,
+		MaxBodyBytes:        8,
+	}}
+	cases := []struct {
+		raw        string
+		statusCode int
+	}{
+		{"GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\n\r\n", 414},
+		{"GET / HTTP/1.1\r\nA: " + strings.Repeat("x", 80) + "\r\n\r\n", 431},
+		{"POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n123456789", 413},
+	}
+
+	// Test: Requests over a limit get the matching status and the connection is closed
+	for _, c := range cases {
+		client, r, done := startConnection(t, s)
+		go client.Write([]byte(c.raw))
+		resp, _ := readResponse(t, r)
+		assert.Equal(t, c.statusCode, resp.StatusCode)
+		assert.True(t, resp.Close)
+		<-done
+	}
+}
+
+func TestRunConnectionStreamsBody(t *testing.T) {
+	s := &Server{timeouts: DefaultTimeouts, limits: request.Limits{MaxBodyBytes: 16}, streamBody: true}
+	s.handler = func(w *response.Writer, req *request.Request) error {
+		if req.RequestLine.RequestTarget == "/ignore" {
+			return nil
+		}
+		body, err := io.ReadAll(req.BodyReader)
+		if err != nil {
+			return err
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		_, err = w.WriteBody(body)
+		return err
+	}
+
+	// Test: Handler reads the body from the connection
+	client, r, done := startConnection(t, s)
+	go client.Write([]byte("POST /echo HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"))
+	resp, body := readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+
+	// Test: Unread body is drained before the next request
+	go client.Write([]byte("POST /ignore HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+		"POST /echo HTTP/1.1\r\nContent-Length: 2\r\n\r\nok"))
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	_, body = readResponse(t, r)
+	assert.Equal(t, "ok", body)
+
+	// Test: Body over the limit is a 413 and the connection is closed
+	go client.Write([]byte("POST /echo HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n10\r\n0123456789abcdef\r\n1\r\nx\r\n0\r\n\r\n"))
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 413, resp.StatusCode)
+	<-done
+}
+
+func TestRunConnectionRejectsSmuggling(t *testing.T) {
+	s := &Server{handler: echoTargetHandler, timeouts: DefaultTimeouts, limits: request.DefaultLimits}
+
+	// Test: Ambiguous framing gets a 400 and the connection is closed before
+	// the smuggled request can be read
+	client, r, done := startConnection(t, s)
+	go client.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET /admin HTTP/1.1\r\n\r\n"))
+	resp, _ := readResponse(t, r)
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.True(t, resp.Close)
+	<-done
+
+	// Test: Unknown transfer coding gets a 501 and the connection is closed
+	client, r, done = startConnection(t, s)
+	go client.Write([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\nGET /admin HTTP/1.1\r\n\r\n"))
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 501, resp.StatusCode)
+	assert.True(t, resp.Close)
+	<-done
+}
+
+func TestRunConnectionHeaderPolicy(t *testing.T) {
+	redirectHandler := func(w *response.Writer, req *request.Request) error {
+		next, err := url.QueryUnescape(strings.TrimPrefix(req.RequestLine.RequestTarget, "/?next="))
+		if err != nil {
+			return err
+		}
+		h := response.GetDefaultHeaders(0)
+		h.Set("Location", next)
+		w.WriteStatusLine(response.StatusFound)
+		return w.WriteHeaders(h)
+	}
+	raw := []byte("GET /?next=/home%0D%0ASet-Cookie:%20session=evil HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	// Test: A reflected CRLF is rejected and the handler's error becomes a 500
+	s := &Server{handler: redirectHandler, timeouts: DefaultTimeouts, logger: log.New(io.Discard, "", 0)}
+	client, r, _ := startConnection(t, s)
+	go client.Write(raw)
+	resp, _ := readResponse(t, r)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Empty(t, resp.Header.Values("Set-Cookie"))
+
+	// Test: The sanitize policy keeps the response but not the injected line
+	s.headerPolicy = response.HeaderPolicySanitize
+	client, r, _ = startConnection(t, s)
+	go client.Write(raw)
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 302, resp.StatusCode)
+	assert.Equal(t, "/home  Set-Cookie: session=evil", resp.Header.Get("Location"))
+	assert.Empty(t, resp.Header.Values("Set-Cookie"))
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+)
+
+// tlsConfig builds the TLS configuration described by cfg, or returns nil if
+// the server should speak plaintext. The caller's TLSConfig is never
+// modified.
+func tlsConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSConfig == nil && cfg.CertFile == "" && cfg.KeyFile == "" && cfg.ClientCAFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if cfg.TLSConfig != nil {
+		config = cfg.TLSConfig.Clone()
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, fmt.Errorf("TLS config has no certificate")
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA file %s has no certificates", cfg.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if cfg.ClientAuth != tls.NoClientCert {
+		config.ClientAuth = cfg.ClientAuth
+	}
+	return config, nil
+}
+
+// handshake completes the TLS handshake of a TLS connection within the read
+// header timeout and returns the negotiated state. Plaintext connections
+// get a nil state. It reports false if the handshake failed, in which case
+// the connection should be dropped.
+func handshake(s *Server, conn io.ReadWriteCloser, timeouts Timeouts) (*tls.ConnectionState, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, true
+	}
+	setReadDeadline(conn, timeouts.ReadHeader)
+	setWriteDeadline(conn, timeouts.ReadHeader)
+	if err := tlsConn.Handshake(); err != nil {
+		if !isClosed(s) {
+			logf(s, "TLS handshake error from %s: %v", tlsConn.RemoteAddr(), err)
+		}
+		return nil, false
+	}
+	setWriteDeadline(conn, 0)
+	state := tlsConn.ConnectionState()
+	return &state, true
+}
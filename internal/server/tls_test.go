@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"webserver/internal/request"
+	"webserver/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCert creates a self-signed certificate for name and writes it and
+// its key as PEM files in a temporary directory.
+func newTestCert(t *testing.T, name string, usage x509.ExtKeyUsage) (tls.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	cert.Leaf, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, certFile, keyFile
+}
+
+func tlsStateHandler(w *response.Writer, req *request.Request) error {
+	body := "plaintext"
+	if req.TLS != nil {
+		clientName := "-"
+		if len(req.TLS.PeerCertificates) > 0 {
+			clientName = req.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		body = fmt.Sprintf("%s %s %s", tls.VersionName(req.TLS.Version), req.TLS.ServerName, clientName)
+		if tls.CipherSuiteName(req.TLS.CipherSuite) == "" {
+			body = "no cipher suite"
+		}
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	_, err := w.WriteBody([]byte(body))
+	return err
+}
+
+func tlsGet(t *testing.T, s *Server, config *tls.Config) (*http.Response, string, error) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", Addr(s).String(), config)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")); err != nil {
+		return nil, "", err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var body bytes.Buffer
+	_, err = body.ReadFrom(resp.Body)
+	return resp, body.String(), err
+}
+
+func TestServeTLS(t *testing.T) {
+	serverCert, certFile, keyFile := newTestCert(t, "localhost", x509.ExtKeyUsageServerAuth)
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.Leaf)
+
+	// Test: Certificate and key files, with the TLS state exposed to handlers
+	s, err := ServeConfig(Config{
+		Addr:     "127.0.0.1:0",
+		Handler:  tlsStateHandler,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Logger:   log.New(io.Discard, "", 0),
+	})
+	require.NoError(t, err)
+	defer Close(s)
+	resp, body, err := tlsGet(t, s, &tls.Config{RootCAs: roots, ServerName: "localhost", MinVersion: tls.VersionTLS13})
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "TLS 1.3 localhost -", body)
+
+	// Test: Plaintext client fails the handshake
+	conn, r := dial(t, s)
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	_, err = http.ReadResponse(r, nil)
+	assert.Error(t, err)
+
+	// Test: In-memory tls.Config
+	s, err = ServeConfig(Config{
+		Addr:      "127.0.0.1:0",
+		Handler:   tlsStateHandler,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{serverCert}, MaxVersion: tls.VersionTLS12},
+	})
+	require.NoError(t, err)
+	defer Close(s)
+	_, body, err = tlsGet(t, s, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, "TLS 1.2 localhost -", body)
+
+	// Test: TLS config without a certificate is rejected
+	_, err = ServeConfig(Config{Addr: "127.0.0.1:0", Handler: tlsStateHandler, TLSConfig: &tls.Config{}})
+	assert.Error(t, err)
+	_, err = ServeConfig(Config{Addr: "127.0.0.1:0", Handler: tlsStateHandler, CertFile: "missing.pem", KeyFile: "missing.pem"})
+	assert.Error(t, err)
+}
+
+func TestServeMutualTLS(t *testing.T) {
+	serverCert, certFile, keyFile := newTestCert(t, "localhost", x509.ExtKeyUsageServerAuth)
+	clientCert, clientCAFile, _ := newTestCert(t, "client", x509.ExtKeyUsageClientAuth)
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.Leaf)
+
+	// Test: Client certificate is required and exposed to handlers
+	s, err := ServeConfig(Config{
+		Addr:         "127.0.0.1:0",
+		Handler:      tlsStateHandler,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: clientCAFile,
+		Logger:       log.New(io.Discard, "", 0),
+	})
+	require.NoError(t, err)
+	defer Close(s)
+	_, body, err := tlsGet(t, s, &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{clientCert}})
+	require.NoError(t, err)
+	assert.Equal(t, "TLS 1.3 localhost client", body)
+
+	_, _, err = tlsGet(t, s, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	assert.Error(t, err)
+
+	// Test: Optional client certificate
+	s, err = ServeConfig(Config{
+		Addr:         "127.0.0.1:0",
+		Handler:      tlsStateHandler,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: clientCAFile,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	require.NoError(t, err)
+	defer Close(s)
+	_, body, err = tlsGet(t, s, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, "TLS 1.3 localhost -", body)
+	_, body, err = tlsGet(t, s, &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{clientCert}})
+	require.NoError(t, err)
+	assert.Equal(t, "TLS 1.3 localhost client", body)
+}